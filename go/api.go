@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+const (
+	defaultHitsPerPage = 50
+	maxHitsPerPage     = 500
+)
+
+type hitsPage struct {
+	Hash    string `json:"hash"`
+	Total   int    `json:"total"` // matching hits across all pages
+	Page    int    `json:"page"`
+	PerPage int    `json:"per_page"`
+	Hits    []Hit  `json:"hits"`
+}
+
+// parseDay accepts either a bare date or a full RFC 3339 timestamp
+func parseDay(value string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// A hitFilter narrows hits down by the query parameters shared by the hit
+// APIs: from and to (dates or RFC 3339 times; to is exclusive), bot ("true"
+// for bots only, "false" for humans only), country and referrer (a domain,
+// which also matches its subdomains)
+type hitFilter struct {
+	from, to time.Time
+	bot      string
+	country  string
+	referrer string
+}
+
+func parseHitFilter(q url.Values) (hitFilter, error) {
+	var f hitFilter
+	var err error
+	if v := q.Get("from"); v != "" {
+		f.from, err = parseDay(v)
+		if err != nil {
+			return f, errors.New("invalid from: " + v)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		f.to, err = parseDay(v)
+		if err != nil {
+			return f, errors.New("invalid to: " + v)
+		}
+	}
+
+	f.bot = q.Get("bot")
+	if f.bot != "" && f.bot != "true" && f.bot != "false" {
+		return f, errors.New("bot must be true or false")
+	}
+
+	if !f.from.IsZero() && !f.to.IsZero() && !f.to.After(f.from) {
+		return f, errors.New("to must be after from")
+	}
+
+	f.country = strings.ToUpper(strings.TrimSpace(q.Get("country")))
+	if f.country != "" && !validCountry.MatchString(f.country) {
+		return f, errors.New("country must be a two letter country code like DE")
+	}
+	f.referrer = strings.ToLower(strings.TrimSpace(q.Get("referrer")))
+	if f.referrer != "" && !validReferrerFilter.MatchString(f.referrer) && net.ParseIP(f.referrer) == nil {
+		return f, errors.New("referrer must be a domain like example.com")
+	}
+	return f, nil
+}
+
+// validReferrerFilter is a domain name, which referrer= matches against
+// the host of each hit's referrer
+var validReferrerFilter = regexp.MustCompile(`^[\p{L}\p{N}_-]+(\.[\p{L}\p{N}_-]+)*$`)
+
+func (f hitFilter) apply(hits []Hit) []Hit {
+	var matched []Hit
+	for _, h := range hits {
+		if !f.from.IsZero() && h.Time.Before(f.from) {
+			continue
+		}
+		if !f.to.IsZero() && !h.Time.Before(f.to) {
+			continue
+		}
+		if f.bot != "" && isBot(h.UserAgent) != (f.bot == "true") {
+			continue
+		}
+		if f.country != "" && h.Country != f.country {
+			continue
+		}
+		if f.referrer != "" {
+			host := referrerHost(h.Referrer)
+			if host != f.referrer && !strings.HasSuffix(host, "."+f.referrer) {
+				continue
+			}
+		}
+		matched = append(matched, h)
+	}
+	return matched
+}
+
+// apiHitsHandler serves GET /api/hits/<hash>. Besides the hitFilter
+// parameters it takes sort (time, ua, referrer or country, prefixed with
+// "-" for descending), page and per_page.
+func apiHitsHandler(w http.ResponseWriter, r *http.Request, m string) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+
+	q := r.URL.Query()
+	filter, err := parseHitFilter(q)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sortBy := q.Get("sort")
+	if sortBy == "" {
+		sortBy = "-time"
+	}
+	descending := strings.HasPrefix(sortBy, "-")
+	key := strings.TrimPrefix(sortBy, "-")
+	if key != "time" && key != "ua" && key != "referrer" && key != "country" {
+		apiError(w, http.StatusBadRequest, "cannot sort by "+key)
+		return
+	}
+
+	page := 1
+	if v := q.Get("page"); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			apiError(w, http.StatusBadRequest, "page must be a positive integer")
+			return
+		}
+	}
+	perPage := defaultHitsPerPage
+	if v := q.Get("per_page"); v != "" {
+		perPage, err = strconv.Atoi(v)
+		if err != nil || perPage < 1 || perPage > maxHitsPerPage {
+			apiError(w, http.StatusBadRequest, "per_page must be between 1 and "+strconv.Itoa(maxHitsPerPage))
+			return
+		}
+	}
+
+	hits, err := store.LoadHits(m)
+	if os.IsNotExist(err) {
+		apiError(w, http.StatusNotFound, "no such link")
+		return
+	} else if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	matched := filter.apply(hits)
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if descending {
+			a, b = b, a
+		}
+		switch key {
+		case "ua":
+			return a.UserAgent < b.UserAgent
+		case "referrer":
+			return a.Referrer < b.Referrer
+		case "country":
+			return a.Country < b.Country
+		}
+		return a.Time.Before(b.Time)
+	})
+
+	result := hitsPage{Hash: m, Total: len(matched), Page: page, PerPage: perPage, Hits: []Hit{}}
+	start := (page - 1) * perPage
+	if start < len(matched) {
+		end := start + perPage
+		if end > len(matched) {
+			end = len(matched)
+		}
+		result.Hits = matched[start:end]
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
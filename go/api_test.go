@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseHitFilter(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{query: ""},
+		{query: "from=2026-01-01&to=2026-02-01"},
+		{query: "from=2026-01-01T10:00:00Z&to=2026-01-01T11:00:00Z"},
+		{query: "from=2026-02-01"},
+		{query: "country=de"},
+		{query: "country=EU"},
+		{query: "referrer=news.example.com"},
+		{query: "referrer=Example.COM"},
+		{query: "referrer=bücher.example"},
+		{query: "referrer=192.0.2.1"},
+		{query: "bot=false"},
+		{query: "from=yesterday", wantErr: true},
+		{query: "to=2026-13-01", wantErr: true},
+		{query: "from=2026-02-01&to=2026-01-01", wantErr: true},
+		{query: "from=2026-02-01&to=2026-02-01", wantErr: true},
+		{query: "bot=maybe", wantErr: true},
+		{query: "country=Germany", wantErr: true},
+		{query: "country=D", wantErr: true},
+		{query: "country=D1", wantErr: true},
+		{query: "referrer=https://example.com/page", wantErr: true},
+		{query: "referrer=example..com", wantErr: true},
+		{query: "referrer=.example.com", wantErr: true},
+		{query: "referrer=exa mple.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		q, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = parseHitFilter(q)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseHitFilter(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHitFilterErrorsAre400s(t *testing.T) {
+	inTempDir(t)
+	saveTestLink(t, "abc1234", "https://example.com/")
+	for _, query := range []string{"country=Germany", "referrer=http://x/", "from=2026-02-01&to=2026-01-01"} {
+		w := httptest.NewRecorder()
+		apiHitsHandler(w, httptest.NewRequest(http.MethodGet, "/api/hits/abc1234?"+query, nil), "abc1234")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET /api/hits/abc1234?%s = %d, want 400", query, w.Code)
+		}
+	}
+}
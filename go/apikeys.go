@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API keys are issued with a scope and kept with only a hash of their
+// secret, so where they're kept doesn't give them away: in the store's
+// shared state when it has one, so every instance accepts them, and
+// otherwise in apiKeysFile. A key looks
+// like "la_<id>_<secret>"; the id is what it's listed and revoked by.
+type apiKey struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scope   string    `json:"scope"`
+	Created time.Time `json:"created"`
+	Hash    string    `json:"hash,omitempty"` // hex SHA-256 of the secret
+}
+
+// what each scope lets a key do
+const (
+	scopeFull   = "full"   // everything
+	scopeRead   = "read"   // GET and HEAD requests
+	scopeCreate = "create" // creating and validating links, nothing else
+)
+
+var apiKeysFile = "apikeys.json"
+
+// apiKeysMu serializes changes to apiKeysFile
+var apiKeysMu sync.Mutex
+
+func loadAPIKeys() ([]apiKey, error) {
+	if ss := shared(); ss != nil {
+		values, err := ss.ListState("apikey/")
+		if err != nil {
+			return nil, err
+		}
+		var keys []apiKey
+		for _, value := range values {
+			var k apiKey
+			err = json.Unmarshal(value, &k)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		}
+		return keys, nil
+	}
+
+	contents, err := os.ReadFile(apiKeysFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var keys []apiKey
+	err = json.Unmarshal(contents, &keys)
+	return keys, err
+}
+
+func saveAPIKeys(keys []apiKey) error {
+	contents, err := json.MarshalIndent(keys, "", "\t")
+	if err != nil {
+		return err
+	}
+	temp := apiKeysFile + ".tmp"
+	err = os.WriteFile(temp, contents, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp, apiKeysFile)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func validScope(scope string) bool {
+	return scope == scopeFull || scope == scopeRead || scope == scopeCreate
+}
+
+// issueAPIKey makes a new key and returns it along with the whole key, which
+// isn't kept anywhere
+func issueAPIKey(name, scope string) (apiKey, string, error) {
+	if !validScope(scope) {
+		return apiKey{}, "", fmt.Errorf("scope must be %s, %s or %s", scopeFull, scopeRead, scopeCreate)
+	}
+	id, err := randomCode(8)
+	if err != nil {
+		return apiKey{}, "", err
+	}
+	secret, err := randomCode(32)
+	if err != nil {
+		return apiKey{}, "", err
+	}
+
+	k := apiKey{ID: id, Name: name, Scope: scope, Created: time.Now(), Hash: hashSecret(secret)}
+	if ss := shared(); ss != nil {
+		contents, err := json.Marshal(k)
+		if err != nil {
+			return apiKey{}, "", err
+		}
+		err = ss.PutState("apikey/"+id, contents, time.Time{})
+		if err != nil {
+			return apiKey{}, "", err
+		}
+		return k, "la_" + id + "_" + secret, nil
+	}
+
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	keys, err := loadAPIKeys()
+	if err != nil {
+		return apiKey{}, "", err
+	}
+	err = saveAPIKeys(append(keys, k))
+	if err != nil {
+		return apiKey{}, "", err
+	}
+	return k, "la_" + id + "_" + secret, nil
+}
+
+var errNoSuchKey = errors.New("no such API key")
+
+func revokeAPIKey(id string) error {
+	if ss := shared(); ss != nil {
+		err := ss.DeleteState("apikey/" + id)
+		if os.IsNotExist(err) {
+			return errNoSuchKey
+		}
+		return err
+	}
+
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	keys, err := loadAPIKeys()
+	if err != nil {
+		return err
+	}
+	for i, k := range keys {
+		if k.ID == id {
+			return saveAPIKeys(append(keys[:i], keys[i+1:]...))
+		}
+	}
+	return errNoSuchKey
+}
+
+// apiKeysIssued reports whether any keys exist, in which case the API
+// isn't open to everyone
+func apiKeysIssued() bool {
+	keys, err := loadAPIKeys()
+	return err != nil || len(keys) > 0
+}
+
+// lookupAPIKey finds the key a bearer token is, if it's one of ours
+func lookupAPIKey(token string) (apiKey, bool) {
+	rest, found := strings.CutPrefix(token, "la_")
+	if !found {
+		return apiKey{}, false
+	}
+	id, secret, found := strings.Cut(rest, "_")
+	if !found {
+		return apiKey{}, false
+	}
+	keys, err := loadAPIKeys()
+	if err != nil {
+		log.Print("reading API keys: ", err)
+		return apiKey{}, false
+	}
+	for _, k := range keys {
+		if k.ID == id && subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(k.Hash)) == 1 {
+			return k, true
+		}
+	}
+	return apiKey{}, false
+}
+
+// allows reports whether k's scope covers r. Only full keys can manage
+// keys.
+func (k apiKey) allows(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/v1/keys") {
+		return k.Scope == scopeFull
+	}
+	switch k.Scope {
+	case scopeFull:
+		return true
+	case scopeRead:
+		return r.Method == http.MethodGet || r.Method == http.MethodHead
+	case scopeCreate:
+		p := r.URL.Path
+		return r.Method == http.MethodPost && (p == "/api/v1/links" || p == "/api/validate")
+	}
+	return false
+}
+
+// apiKeysHandler serves /api/v1/keys: GET lists keys (never their secrets),
+// POST issues one from {"name": ..., "scope": ...} and DELETE
+// /api/v1/keys/<id> revokes one
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	// with no auth at all everyone counts as an admin, and the first key
+	//	they issued would lock everyone else out, so that one has to come
+	//	from -new-api-key
+	if !authConfigured() {
+		apiError(w, http.StatusForbidden, errNoAuth+"; issue the first API key with -new-api-key")
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/keys"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		keys, err := loadAPIKeys()
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if keys == nil {
+			keys = []apiKey{}
+		}
+		for i := range keys {
+			keys[i].Hash = ""
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+		writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+
+	case id == "" && r.Method == http.MethodPost:
+		var req struct {
+			Name  string `json:"name"`
+			Scope string `json:"scope"`
+		}
+		err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		if req.Scope == "" {
+			req.Scope = scopeRead
+		}
+		k, key, err := issueAPIKey(req.Name, req.Scope)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("audit: %s issued API key %s (%s) with scope %s", r.RemoteAddr, k.ID, k.Name, k.Scope)
+		k.Hash = ""
+		writeJSON(w, http.StatusCreated, map[string]any{"key": key, "info": k})
+
+	case id != "" && r.Method == http.MethodDelete:
+		err := revokeAPIKey(id)
+		if err == errNoSuchKey {
+			apiError(w, http.StatusNotFound, err.Error())
+			return
+		} else if err != nil {
+			apiError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		log.Printf("audit: %s revoked API key %s", r.RemoteAddr, id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		apiError(w, http.StatusMethodNotAllowed, "use GET or POST on /api/v1/keys and DELETE on /api/v1/keys/<id>")
+	}
+}
+
+// runAPIKeyCommand handles -new-api-key, -revoke-api-key and -list-api-keys
+func runAPIKeyCommand(newKey, scope, revoke string, list bool) error {
+	switch {
+	case newKey != "":
+		k, key, err := issueAPIKey(newKey, scope)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n(id %s, scope %s; this is the only time the key is shown)\n", key, k.ID, k.Scope)
+	case revoke != "":
+		err := revokeAPIKey(revoke)
+		if err != nil {
+			return err
+		}
+		fmt.Println("revoked", revoke)
+	case list:
+		keys, err := loadAPIKeys()
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			fmt.Printf("%s\t%s\t%s\t%s\n", k.ID, k.Scope, k.Created.Format(time.RFC3339), k.Name)
+		}
+	}
+	return nil
+}
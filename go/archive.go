@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// archiveLink stops a link redirecting while keeping its analytics.
+// Archiving can be undone with a bulk update setting archived to false.
+func archiveLink(hash string) error {
+	archived := true
+	err := updateLink(hash, &linkUpdate{Archived: &archived})
+	if err != nil {
+		return err
+	}
+	invalidateSummary(hash)
+	return nil
+}
+
+// removeLink deletes a link along with every hit recorded for it
+func removeLink(hash string) error {
+	err := store.DeleteLink(hash)
+	if err != nil {
+		return err
+	}
+	invalidateSummary(hash)
+	return nil
+}
+
+// deleteHandler handles POST /delete/<hash>, which removes a link and its
+// hits, or only archives it if the archive form value is set
+func deleteHandler(w http.ResponseWriter, r *http.Request, m string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "deleting a link needs a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archive := r.FormValue("archive") != ""
+	var err error
+	if archive {
+		err = archiveLink(m)
+	} else {
+		err = removeLink(m)
+	}
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	action := "deleted"
+	if archive {
+		action = "archived"
+	}
+	log.Printf("audit: %s %s link %s", r.RemoteAddr, action, m)
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, map[string]string{"hash": m, "status": action})
+		return
+	}
+	if archive {
+		http.Redirect(w, r, appPath("/analytics/"+m), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, appPath("/create/"), http.StatusSeeOther)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// apiToken, when set, has to be sent as "Authorization: Bearer <token>" to
+// use the JSON API
+var apiToken string
+
+// requireAPIToken guards an API route with the static -api-token, issued
+// API keys and/or JWTs, whichever are configured. Someone logged in to the
+// pages can use it too. With none of those the API is open.
+func requireAPIToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apiToken == "" && !jwtEnabled() && !loginEnabled() && !apiKeysIssued() {
+			next(w, r)
+			return
+		}
+		if _, ok := sessionUser(r); ok && loginEnabled() {
+			next(w, r)
+			return
+		}
+
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			unauthorized(w, "missing API token")
+			return
+		}
+		if apiToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) == 1 {
+			next(w, r)
+			return
+		}
+		if k, ok := lookupAPIKey(token); ok {
+			if !k.allows(r) {
+				apiError(w, http.StatusForbidden, "this API key's "+k.Scope+" scope doesn't allow that")
+				return
+			}
+			next(w, r)
+			return
+		}
+		if !jwtEnabled() {
+			unauthorized(w, "invalid API token")
+			return
+		}
+
+		claims, err := verifyJWT(token)
+		if err != nil {
+			unauthorized(w, "invalid token: "+err.Error())
+			return
+		}
+		readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if jwtScope != "" && !readOnly && !claims.hasScope(jwtScope) {
+			apiError(w, http.StatusForbidden, "token is missing the "+jwtScope+" scope")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// authConfigured reports whether there's any way of telling who's making a
+// request: logins, an API token, JWTs or API keys
+func authConfigured() bool {
+	return loginEnabled() || apiToken != "" || jwtEnabled() || apiKeysIssued()
+}
+
+// errNoAuth is why changes to existing links are refused when nothing is
+// configured to say who's asking
+const errNoAuth = "changing or removing links needs logins (-admin-password or -htpasswd) or API auth (-api-token, API keys or JWTs) turned on"
+
+// requireAuth guards pages that change or remove existing links. Unlike
+// requireLogin and requireAPIToken it never lets everyone through: with
+// nothing configured to say who's asking, it refuses. Without logins, the
+// API's token, keys or JWTs are needed instead.
+func requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case loginEnabled():
+			requireLogin(next)(w, r)
+		case authConfigured():
+			requireAPIToken(next)(w, r)
+		default:
+			http.Error(w, errNoAuth, http.StatusForbidden)
+		}
+	}
+}
+
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	apiError(w, http.StatusUnauthorized, message)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// A linkUpdate is a set of changes to make to a link; fields left out of
+// the JSON aren't touched
+type linkUpdate struct {
+	Tags         *[]string `json:"tags"` // replaces every tag
+	AddTags      []string  `json:"add_tags"`
+	RemoveTags   []string  `json:"remove_tags"`
+	Disabled     *bool     `json:"disabled"`
+	Archived     *bool     `json:"archived"`
+	IdleTTL      *Duration `json:"idle_ttl"` // "0s" turns idle expiry off
+	PassQuery    *bool     `json:"pass_query"`
+	Interstitial *bool     `json:"interstitial"`
+	Redirect     *int      `json:"redirect_status"`
+	Title        *string   `json:"title"`
+	Notes        *string   `json:"notes"`
+}
+
+var validTag = regexp.MustCompile("^[a-z0-9][a-z0-9_-]{0,31}$")
+
+func (u *linkUpdate) validate() error {
+	err := validateTags(append(u.AddTags, u.RemoveTags...))
+	if err != nil {
+		return err
+	}
+	if u.Tags != nil {
+		err = validateTags(*u.Tags)
+		if err != nil {
+			return err
+		}
+	}
+	if u.IdleTTL != nil && *u.IdleTTL < 0 {
+		return errors.New("idle_ttl can't be negative")
+	}
+	if u.Redirect != nil && !validRedirectStatus(*u.Redirect) {
+		return errors.New("redirect_status must be 301, 302, 307 or 308")
+	}
+	if u.Title != nil {
+		title, err := cleanTitle(*u.Title)
+		if err != nil {
+			return err
+		}
+		u.Title = &title
+	}
+	if u.Notes != nil {
+		notes, err := cleanNotes(*u.Notes)
+		if err != nil {
+			return err
+		}
+		u.Notes = &notes
+	}
+	return nil
+}
+
+// apply changes l to match u. Tags are always put in a new slice rather
+// than changed in place, since l may share them with other copies of it.
+func (u *linkUpdate) apply(l *Link) {
+	if u.Tags != nil {
+		l.Tags = append([]string(nil), *u.Tags...)
+	}
+	for _, tag := range u.AddTags {
+		if !l.hasTag(tag) {
+			l.Tags = append(l.Tags[:len(l.Tags):len(l.Tags)], tag)
+		}
+	}
+	for _, tag := range u.RemoveTags {
+		kept := make([]string, 0, len(l.Tags))
+		for _, t := range l.Tags {
+			if t != tag {
+				kept = append(kept, t)
+			}
+		}
+		l.Tags = kept
+	}
+	if u.Disabled != nil {
+		l.Disabled = *u.Disabled
+	}
+	if u.Archived != nil {
+		l.Archived = *u.Archived
+	}
+	if u.IdleTTL != nil {
+		l.IdleTTL = *u.IdleTTL
+	}
+	if u.PassQuery != nil {
+		l.PassQuery = *u.PassQuery
+	}
+	if u.Interstitial != nil {
+		l.Interstitial = *u.Interstitial
+	}
+	if u.Redirect != nil {
+		l.RedirectStatus = *u.Redirect
+	}
+	if u.Title != nil {
+		l.Title = *u.Title
+	}
+	if u.Notes != nil {
+		l.Notes = *u.Notes
+	}
+}
+
+func (l *Link) hasTag(tag string) bool {
+	for _, t := range l.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// updateLink loads a link, applies u to it and saves it
+func updateLink(hash string, u *linkUpdate) error {
+	l, err := store.LoadLink(hash)
+	if err != nil {
+		return err
+	}
+	u.apply(l)
+	if l.IdleTTL > 0 && !l.Expires.IsZero() {
+		return errIdleAndFixedExpiry
+	}
+	return store.SaveLink(l)
+}
+
+const maxBulkUpdate = 100
+
+type bulkResult struct {
+	Hash  string `json:"hash"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// bulkUpdateHandler serves POST /api/links/bulk-update, which takes
+// {"hashes": [...], "update": {...}} and applies the update to each link in
+// turn. A link that fails doesn't stop the rest, so the response lists how
+// each one went.
+func bulkUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		apiError(w, http.StatusMethodNotAllowed, "only POST is supported")
+		return
+	}
+	// it can archive and disable links, so it's never open to everyone
+	if !authConfigured() {
+		apiError(w, http.StatusForbidden, errNoAuth)
+		return
+	}
+
+	var req struct {
+		Hashes []string   `json:"hashes"`
+		Update linkUpdate `json:"update"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if len(req.Hashes) == 0 || len(req.Hashes) > maxBulkUpdate {
+		apiError(w, http.StatusBadRequest, "send between 1 and "+strconv.Itoa(maxBulkUpdate)+" hashes")
+		return
+	}
+	err = req.Update.validate()
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// turning on idle expiry for a link that already has a fixed one is
+	//	a mistake in the request, not something to report link by link
+	if req.Update.IdleTTL != nil && *req.Update.IdleTTL > 0 {
+		for _, hash := range req.Hashes {
+			if !validHash.MatchString(hash) {
+				continue
+			}
+			l, err := store.LoadLink(hash)
+			if err == nil && canSee(r, l) && !l.Expires.IsZero() {
+				apiError(w, http.StatusBadRequest, hash+": "+errIdleAndFixedExpiry.Error())
+				return
+			}
+		}
+	}
+
+	results := []bulkResult{}
+	for _, hash := range req.Hashes {
+		result := bulkResult{Hash: hash}
+		if !validHash.MatchString(hash) {
+			result.Error = "invalid hash"
+		} else if l, err := store.LoadLink(hash); err == nil && !canSee(r, l) {
+			result.Error = "no such link"
+		} else if err := updateLink(hash, &req.Update); os.IsNotExist(err) {
+			result.Error = "no such link"
+		} else if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.OK = true
+			invalidateSummary(hash)
+		}
+		results = append(results, result)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": results})
+}
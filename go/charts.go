@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// Charts are drawn as inline SVG on the server, so the analytics page
+// still needs no JavaScript
+
+const (
+	chartWidth  = 600
+	chartHeight = 160
+	barHeight   = 18
+	maxBars     = 10
+)
+
+// timeChart draws a timeseries as columns, one per bucket
+func timeChart(ts *Timeseries) template.HTML {
+	if ts == nil || len(ts.Buckets) == 0 {
+		return ""
+	}
+	most := 0
+	for _, b := range ts.Buckets {
+		if b.Clicks > most {
+			most = b.Clicks
+		}
+	}
+	if most == 0 {
+		return ""
+	}
+
+	layout := "2006-01-02"
+	if ts.Granularity == "hour" {
+		layout = "2006-01-02 15:04"
+	}
+	width := float64(chartWidth) / float64(len(ts.Buckets))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg class="chart" viewBox="0 0 %d %d" width="%d" height="%d" role="img" aria-label="clicks by %s">`,
+		chartWidth, chartHeight+20, chartWidth, chartHeight+20, ts.Granularity)
+	for i, b := range ts.Buckets {
+		h := float64(b.Clicks) / float64(most) * chartHeight
+		fmt.Fprintf(&sb, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="steelblue"><title>%s: %d</title></rect>`,
+			float64(i)*width, chartHeight-h, width*0.9, h, b.Start.Format(layout), b.Clicks)
+	}
+	first, last := ts.Buckets[0].Start.Format(layout), ts.Buckets[len(ts.Buckets)-1].Start.Format(layout)
+	fmt.Fprintf(&sb, `<text x="0" y="%d" font-size="12">%s</text>`, chartHeight+15, first)
+	fmt.Fprintf(&sb, `<text x="%d" y="%d" font-size="12" text-anchor="end">%s</text>`, chartWidth, chartHeight+15, last)
+	fmt.Fprintf(&sb, `<text x="0" y="12" font-size="12">%d</text>`, most)
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
+
+// barChart draws the top rows of a breakdown as horizontal bars
+func barChart(rows []Count) template.HTML {
+	if len(rows) == 0 {
+		return ""
+	}
+	if len(rows) > maxBars {
+		rows = rows[:maxBars]
+	}
+	most := rows[0].Count
+	for _, row := range rows {
+		if row.Count > most {
+			most = row.Count
+		}
+	}
+	if most == 0 {
+		return ""
+	}
+
+	const labelWidth = 200
+	height := len(rows) * (barHeight + 4)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg class="chart" viewBox="0 0 %d %d" width="%d" height="%d">`, chartWidth, height, chartWidth, height)
+	for i, row := range rows {
+		y := i * (barHeight + 4)
+		w := float64(row.Count) / float64(most) * (chartWidth - labelWidth - 50)
+		fmt.Fprintf(&sb, `<text x="%d" y="%d" font-size="12" text-anchor="end">%s</text>`,
+			labelWidth-6, y+barHeight-5, template.HTMLEscapeString(row.Key))
+		fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="%.2f" height="%d" fill="steelblue"></rect>`, labelWidth, y, w, barHeight)
+		fmt.Fprintf(&sb, `<text x="%.2f" y="%d" font-size="12">%d</text>`, labelWidth+w+4, y+barHeight-5, row.Count)
+	}
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
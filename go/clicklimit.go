@@ -0,0 +1,19 @@
+package main
+
+// limitMessage is shown to people who click a link that has used up its
+// MaxClicks
+var limitMessage = "This link has reached its click limit."
+
+// overLimit reports whether a link has had all the clicks it's allowed.
+// Clicks landing at the same moment can each see the last free slot, so a
+// busy link can go a click or two over.
+func overLimit(l *Link) (bool, error) {
+	if l.MaxClicks <= 0 {
+		return false, nil
+	}
+	s, err := cachedSummary(l.Hash)
+	if err != nil {
+		return false, err
+	}
+	return s.Total >= l.MaxClicks, nil
+}
@@ -0,0 +1,21 @@
+// linkanalytics pageview collector. Put it on a page with
+//
+//	<script async src="https://links.example.com/collect.js" data-link="HASH"></script>
+//
+// and every load of the page is sent to /collect/HASH, along with where the
+// visitor came from, their language and their screen size.
+(function () {
+	var script = document.currentScript;
+	if (!script || !script.dataset.link || !navigator.sendBeacon) {
+		return;
+	}
+	// wherever this script was served from, under any base path
+	var base = script.src.replace(/\/collect\.js(?:[?#].*)?$/, "");
+
+	var data = new URLSearchParams();
+	data.set("page", location.origin + location.pathname);
+	data.set("ref", document.referrer);
+	data.set("lang", navigator.language || "");
+	data.set("screen", screen.width + "x" + screen.height);
+	navigator.sendBeacon(base + "/collect/" + encodeURIComponent(script.dataset.link), data);
+})();
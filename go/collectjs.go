@@ -0,0 +1,56 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// collectJS is the script pages embed to send their pageviews to /collect/
+//
+//go:embed collect.js
+var collectJS []byte
+
+// collectJSHandler serves GET /collect.js
+func collectJSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(collectJS)))
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(collectJS)
+}
+
+var validScreen = regexp.MustCompile(`^[0-9]{1,5}x[0-9]{1,5}$`)
+
+// addPageview fills in what /collect.js sends along with a beacon: the page
+// it was on, that page's referrer (the request's own Referer is the page
+// itself) and the browser's language and screen size. Anything malformed
+// is left out rather than failing the beacon.
+func (h *Hit) addPageview(r *http.Request) {
+	if page := r.FormValue("page"); len(page) <= 2048 {
+		// only the page's address; its query string can carry anything
+		u, err := url.Parse(page)
+		if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			h.Page = u.Scheme + "://" + u.Host + u.EscapedPath()
+		}
+	}
+	// an empty ref means the visitor came straight to the page
+	if _, found := r.Form["ref"]; found && len(r.FormValue("ref")) <= 2048 {
+		h.Referrer = r.FormValue("ref")
+	}
+	if lang := r.FormValue("lang"); len(lang) <= 35 && validLanguage.MatchString(lang) {
+		h.Language = lang
+	}
+	if screen := r.FormValue("screen"); validScreen.MatchString(screen) {
+		h.Screen = screen
+	}
+}
+
+// validLanguage is a BCP 47 tag like en-US, loosely
+var validLanguage = regexp.MustCompile(`^[a-zA-Z]{2,8}(-[a-zA-Z0-9]{1,8})*$`)
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+)
+
+// maxCompared caps how many links /compare will load at once
+const maxCompared = 5
+
+var validHash = regexp.MustCompile("^[a-zA-Z0-9]+$")
+
+type compareDay struct {
+	Day    string
+	Counts []int // one per compared link, in the same order
+}
+
+type comparePage struct {
+	Links   []*LinkAnalytics
+	Missing []string
+	Skipped int // hashes left out because of maxCompared
+	Days    []compareDay
+}
+
+// compareHandler serves /compare?hash=a&hash=b..., showing each link's
+// summary side by side
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	hashes := r.URL.Query()["hash"]
+	p := &comparePage{}
+	if len(hashes) > maxCompared {
+		p.Skipped = len(hashes) - maxCompared
+		hashes = hashes[:maxCompared]
+	}
+
+	for _, hash := range hashes {
+		if !validHash.MatchString(hash) {
+			p.Missing = append(p.Missing, hash)
+			continue
+		}
+		l, err := store.LoadLink(hash)
+		if err != nil || !canSee(r, l) {
+			p.Missing = append(p.Missing, hash)
+			continue
+		}
+		sum, err := cachedSummary(hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p.Links = append(p.Links, &LinkAnalytics{GoTo: l, ShortURL: shareableURL(r, hash), Summary: sum})
+	}
+
+	days := map[string][]int{}
+	for i, a := range p.Links {
+		for _, d := range a.Summary.Daily {
+			if days[d.Day] == nil {
+				days[d.Day] = make([]int, len(p.Links))
+			}
+			days[d.Day][i] = d.Count
+		}
+	}
+	for day, counts := range days {
+		p.Days = append(p.Days, compareDay{day, counts})
+	}
+	sort.Slice(p.Days, func(i, j int) bool { return p.Days[i].Day < p.Days[j].Day })
+
+	err := templates.ExecuteTemplate(w, "compare.html", p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A config file sets flags by name, so anything that can be given on the
+// command line can go in it:
+//
+//	storage = "sqlite"
+//	port = 8443
+//	redact = ["ip", "ua"]
+//
+//	[sqlite]
+//	path = "/var/lib/linkanalytics/links.db"
+//
+// A table's keys are prefixed with its name, so the above sets -sqlite-path.
+// Every flag can also be set with an environment variable named after it,
+// like LINKANALYTICS_SQLITE_PATH. The command line wins over the
+// environment, which wins over the file.
+
+// envName is the environment variable that sets a flag
+func envName(flagName string) string {
+	return "LINKANALYTICS_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadConfig sets every flag not given on the command line from the
+// environment or, failing that, from the config file at path (if any)
+func loadConfig(path string) error {
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	settings := map[string]string{}
+	if path != "" {
+		var file map[string]any
+		_, err := toml.DecodeFile(path, &file)
+		if err != nil {
+			return fmt.Errorf("config %s: %w", path, err)
+		}
+		err = flatten(settings, "", file)
+		if err != nil {
+			return fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+	for name := range settings {
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("config %s: no setting named %s", path, name)
+		}
+	}
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] || f.Name == "config" {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		from := "$" + envName(f.Name)
+		if !ok {
+			value, ok = settings[f.Name]
+			from = path
+		}
+		if !ok {
+			return
+		}
+		if e := f.Value.Set(value); e != nil {
+			err = fmt.Errorf("%s from %s: %w", f.Name, from, e)
+		}
+	})
+	return err
+}
+
+// flatten turns the decoded file into flag names and values. Lists become
+// comma separated, as the flags that take several values expect.
+func flatten(settings map[string]string, prefix string, table map[string]any) error {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := key
+		if prefix != "" {
+			name = prefix + "-" + key
+		}
+		switch v := table[key].(type) {
+		case map[string]any:
+			err := flatten(settings, name, v)
+			if err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			settings[name] = strings.Join(items, ",")
+		case string, bool, int64, float64:
+			settings[name] = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("%s can't be a %T", name, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// attributionWindow is how long after a click a conversion from the same
+// visitor is still credited to it
+var attributionWindow = 30 * 24 * time.Hour
+
+const visitorCookie = "la_visitor"
+
+// visitorID returns the ID of the browser making r and whether it already
+// had one, giving it one if it didn't. Browsers sending DNT aren't given or
+// asked for one.
+func visitorID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Header.Get("DNT") == "1" {
+		return "", false
+	}
+
+	c, err := r.Cookie(visitorCookie)
+	if err == nil && validHash.MatchString(c.Value) && len(c.Value) <= 32 {
+		return c.Value, true
+	}
+
+	id, err := randomCode(16)
+	if err != nil {
+		return "", false
+	}
+	cookie := &http.Cookie{
+		Name:     visitorCookie,
+		Value:    id,
+		Path:     appPath("/"),
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	// conversion beacons come from the destination's pages, so over https
+	//	the cookie is allowed to go along with cross-site requests
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		cookie.SameSite = http.SameSiteNoneMode
+		cookie.Secure = true
+	}
+	http.SetCookie(w, cookie)
+	return id, false
+}
+
+// attribute reports whether a conversion at t by visitor follows one of
+// their clicks on the link closely enough to be credited to it
+func attribute(hash, visitor string, t time.Time) (bool, error) {
+	if visitor == "" {
+		return false, nil
+	}
+	hits, err := store.LoadHits(hash)
+	if err != nil {
+		return false, err
+	}
+	for _, h := range hits {
+		if h.Conversion || h.Visitor != visitor {
+			continue
+		}
+		if !h.Time.After(t) && t.Sub(h.Time) <= attributionWindow {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// corsOrigins are the sites whose pages may call /collect/ and the API with
+// fetch(), from -cors-origins. "*" lets any site. Without any, browsers keep
+// other sites' pages from reading our responses, as they do by default.
+var corsOrigins = map[string]bool{}
+
+// parseCORSOrigins reads -cors-origins, a comma separated list of origins
+// like https://example.com, or "*"
+func parseCORSOrigins(s string) (map[string]bool, error) {
+	origins := map[string]bool{}
+	for _, origin := range strings.Split(s, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+				return nil, fmt.Errorf("origin %q should look like https://example.com", origin)
+			}
+			origin = u.Scheme + "://" + strings.ToLower(u.Host)
+		}
+		origins[origin] = true
+	}
+	return origins, nil
+}
+
+// allowCORS answers preflight OPTIONS requests for handler and adds the
+// headers that let allowed origins read its responses. Preflights are
+// answered before any auth, since browsers never send credentials with
+// them.
+func allowCORS(handler http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(corsOrigins) == 0 {
+			handler(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := corsOrigins["*"] || corsOrigins[strings.ToLower(origin)]
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if allowed {
+			if corsOrigins["*"] {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+		if !preflight {
+			if allowed {
+				w.Header().Set("Access-Control-Expose-Headers", "Location, ETag, Retry-After")
+			}
+			handler(w, r)
+			return
+		}
+
+		if !allowed {
+			http.Error(w, "origin "+origin+" isn't allowed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-None-Match")
+		w.Header().Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// atRest encrypts destinations (and hits, with encryptHits) in link files
+// when a key is configured. Encrypted values are stored as "enc:" followed
+// by the base64 of the nonce and the sealed value.
+var (
+	atRest      cipher.AEAD
+	encryptHits bool
+)
+
+const encryptedPrefix = "enc:"
+
+var errNoKey = errors.New("link file is encrypted but no key was given (set LINKANALYTICS_KEY or -encryption-key-file)")
+
+// newAtRest makes the cipher from a base64 encoded 256 bit AES key
+func newAtRest(encoded string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key isn't base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key is %d bytes, not 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadAtRest reads the key from keyFile, or from LINKANALYTICS_KEY if no
+// file was given. Having neither leaves encryption off.
+func loadAtRest(keyFile string) (cipher.AEAD, error) {
+	encoded := os.Getenv("LINKANALYTICS_KEY")
+	if keyFile != "" {
+		contents, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(contents)
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+	return newAtRest(encoded)
+}
+
+func seal(plain string) (string, error) {
+	nonce := make([]byte, atRest.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := atRest.Seal(nonce, nonce, []byte(plain), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unseal decrypts a value written by seal, passing through values that were
+// stored before encryption was turned on. It fails rather than guess when
+// the key is missing or wrong.
+func unseal(stored string) (string, error) {
+	encoded, found := strings.CutPrefix(stored, encryptedPrefix)
+	if !found {
+		return stored, nil
+	}
+	if atRest == nil {
+		return "", errNoKey
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < atRest.NonceSize() {
+		return "", errors.New("corrupt encrypted value in link file")
+	}
+	nonce, sealed := sealed[:atRest.NonceSize()], sealed[atRest.NonceSize():]
+	plain, err := atRest.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", errors.New("can't decrypt link file; is this the right key?")
+	}
+	return string(plain), nil
+}
+
+// unsealHitLine decrypts the JSON of an encrypted "hit: " line
+func unsealHitLine(line string) (string, error) {
+	rest, found := strings.CutPrefix(line, "hit: "+encryptedPrefix)
+	if !found {
+		return line, nil
+	}
+	plain, err := unseal(encryptedPrefix + rest)
+	if err != nil {
+		return "", err
+	}
+	return "hit: " + plain, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var csvHeader = []string{"time", "user_agent", "referrer", "country", "city", "ip", "source", "method", "destination", "visitor_hash", "conversion", "over_limit", "bot", "error", "utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+
+// csvCell keeps spreadsheets from running a value as a formula, since user
+// agents and referrers are whatever the client sent
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// hitsCSVHandler serves GET /api/v1/links/<hash>/hits.csv, every hit on a
+// link oldest first. It takes the hitFilter parameters.
+func hitsCSVHandler(w http.ResponseWriter, r *http.Request, hash string) {
+	filter, err := parseHitFilter(r.URL.Query())
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hits, err := store.LoadHits(hash)
+	if os.IsNotExist(err) {
+		apiError(w, http.StatusNotFound, "no such link")
+		return
+	} else if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+hash+`-hits.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, h := range filter.apply(hits) {
+		cw.Write([]string{
+			h.Time.Format(time.RFC3339),
+			csvCell(h.UserAgent),
+			csvCell(h.Referrer),
+			h.Country,
+			csvCell(h.City),
+			h.IP,
+			csvCell(h.Source),
+			h.Method,
+			csvCell(h.Destination),
+			h.VisitorHash,
+			strconv.FormatBool(h.Conversion),
+			strconv.FormatBool(h.OverLimit),
+			strconv.FormatBool(isBot(h.UserAgent)),
+			csvCell(h.Error),
+			csvCell(h.UTM["source"]),
+			csvCell(h.UTM["medium"]),
+			csvCell(h.UTM["campaign"]),
+			csvCell(h.UTM["term"]),
+			csvCell(h.UTM["content"]),
+		})
+	}
+	cw.Flush()
+}
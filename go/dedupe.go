@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// existingLink finds a link the requester already has to l's destination,
+// which saving l would otherwise duplicate, or with -ids hash overwrite.
+// Only links that still work count, in the same workspace, belonging to
+// the same user and set up the same way; unless codes are hashes,
+// duplicate asks for a new link anyway.
+func existingLink(r *http.Request, l *Link, duplicate bool) (*Link, error) {
+	if _, hashed := idGenerator.(hashIDs); hashed {
+		// the hash is the destination's, so there's only ever one link
+		//	to it
+		old, err := store.LoadLink(l.Hash)
+		if os.IsNotExist(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		// an edited link keeps the hash of where it used to go, and
+		//	handing it back would lose whatever the new one asked for
+		if !canSee(r, old) || old.Destination != l.Destination || !sameSettings(old, l) {
+			return nil, errCodeTaken
+		}
+		return old, nil
+	}
+	// links that pick between several destinations are their own thing,
+	//	even if their main destination is the same
+	if duplicate || len(l.Mirrors) > 0 || len(l.Variants) > 0 || len(l.Rules) > 0 || len(l.GeoRules) > 0 || len(l.Schedule) > 0 {
+		return nil, nil
+	}
+
+	hashes, err := store.ListLinks()
+	if err != nil {
+		return nil, err
+	}
+	// every link is loaded to find one, so past a point it's cheaper to
+	//	just make another
+	if len(hashes) > maxDedupeScan {
+		return nil, nil
+	}
+	// the oldest one, if there are already duplicates
+	var found *Link
+	for _, hash := range hashes {
+		old, err := store.LoadLink(hash)
+		if err != nil {
+			continue
+		}
+		if old.Destination == l.Destination && old.Workspace == l.Workspace && old.Owner == l.Owner &&
+			!old.Archived && !old.Disabled && !old.Expired() && !old.lapsed() && sameSettings(old, l) &&
+			(found == nil || old.Created.Before(found.Created)) {
+			found = old
+		}
+	}
+	return found, nil
+}
+
+// maxDedupeScan is how many links existingLink will look through for one
+// going to the same place
+const maxDedupeScan = 5000
+
+// linkSettings is everything about a link, other than its destination, that
+// changes what a click on it does. Links only stand in for each other when
+// they match on all of it.
+type linkSettings struct {
+	Ruleset        string                 `json:"ruleset,omitempty"`
+	Rules          []Rule                 `json:"rules,omitempty"`
+	GeoRules       []GeoRule              `json:"geo_rules,omitempty"`
+	Schedule       []ScheduledDestination `json:"schedule,omitempty"`
+	Timezone       string                 `json:"timezone,omitempty"`
+	Mirrors        []string               `json:"mirrors,omitempty"`
+	Variants       []Variant              `json:"variants,omitempty"`
+	RedirectStatus int                    `json:"redirect_status,omitempty"`
+	Interstitial   bool                   `json:"interstitial,omitempty"`
+	PassQuery      bool                   `json:"pass_query,omitempty"`
+	PasswordHash   string                 `json:"password_hash,omitempty"`
+	MaxClicks      int                    `json:"max_clicks,omitempty"`
+	Expires        int64                  `json:"expires,omitempty"`
+	IdleTTL        Duration               `json:"idle_ttl,omitempty"`
+	Fields         map[string]bool        `json:"fields,omitempty"`
+	Webhook        string                 `json:"webhook,omitempty"`
+	Value          float64                `json:"value,omitempty"`
+	Goal           int                    `json:"goal,omitempty"`
+}
+
+// sameSettings reports whether a and b behave the same apart from their
+// destinations. They're compared as JSON so that a missing list and an
+// empty one are the same. Passphrases are salted, so two links with one
+// never match.
+func sameSettings(a, b *Link) bool {
+	settings := func(l *Link) []byte {
+		s := linkSettings{
+			Ruleset: l.Ruleset, Rules: l.Rules, GeoRules: l.GeoRules,
+			Schedule: l.Schedule, Timezone: l.Timezone, Mirrors: l.Mirrors,
+			Variants: l.Variants, RedirectStatus: l.redirectStatus(),
+			Interstitial: l.Interstitial, PassQuery: l.PassQuery,
+			PasswordHash: l.PasswordHash, MaxClicks: l.MaxClicks,
+			IdleTTL: l.IdleTTL, Fields: l.Fields, Webhook: l.Webhook,
+			Value: l.Value, Goal: l.Goal,
+		}
+		if !l.Expires.IsZero() {
+			s.Expires = l.Expires.Unix()
+		}
+		contents, _ := json.Marshal(s)
+		return contents
+	}
+	return bytes.Equal(settings(a), settings(b))
+}
+
+// withExisting marks a redirect after saving as having found an existing
+// link, for the analytics page (or whatever return_to is) to say so
+func withExisting(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	q := u.Query()
+	q.Set("existing", "1")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExistingLinkSettings(t *testing.T) {
+	tests := []struct {
+		name  string
+		old   func(l *Link)
+		new   func(l *Link)
+		found bool
+	}{
+		{name: "plain", found: true},
+		{name: "new one has a passphrase", new: func(l *Link) { l.PasswordHash = "$2a$10$abc" }},
+		{name: "old one has a passphrase", old: func(l *Link) { l.PasswordHash = "$2a$10$abc" }},
+		{name: "max clicks", new: func(l *Link) { l.MaxClicks = 5 }},
+		{name: "expiry", new: func(l *Link) { l.Expires = time.Now().Add(time.Hour) }},
+		{name: "idle ttl", new: func(l *Link) { l.IdleTTL = Duration(time.Hour) }},
+		{name: "fields", new: func(l *Link) { l.Fields = map[string]bool{"ip": false} }},
+		{name: "ruleset", new: func(l *Link) { l.Ruleset = "apps" }},
+		{name: "webhook", new: func(l *Link) { l.Webhook = "https://hooks.example.com/" }},
+		{name: "pass query", new: func(l *Link) { l.PassQuery = true }},
+		{name: "redirect status", new: func(l *Link) { l.RedirectStatus = http.StatusMovedPermanently }},
+		{name: "default redirect status", new: func(l *Link) { l.RedirectStatus = http.StatusFound }, found: true},
+		{name: "same settings", old: func(l *Link) { l.MaxClicks = 5 }, new: func(l *Link) { l.MaxClicks = 5 }, found: true},
+		{name: "empty fields", new: func(l *Link) { l.Fields = map[string]bool{} }, found: true},
+		{name: "title", new: func(l *Link) { l.Title = "launch" }, found: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t)
+			old := &Link{Hash: "old1234", Destination: "https://example.com/", Created: time.Now().Add(-time.Hour)}
+			if tt.old != nil {
+				tt.old(old)
+			}
+			err := store.CreateLink(old)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := &Link{Hash: "new1234", Destination: "https://example.com/", Created: time.Now()}
+			if tt.new != nil {
+				tt.new(l)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/links", nil)
+			got, err := existingLink(r, l, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got != nil) != tt.found {
+				t.Errorf("existingLink() = %v, want found %v", got, tt.found)
+			}
+		})
+	}
+}
+
+func TestExistingLinkHashIDs(t *testing.T) {
+	old := idGenerator
+	idGenerator = hashIDs{}
+	t.Cleanup(func() { idGenerator = old })
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/links", nil)
+
+	inTempDir(t)
+	hash, _ := hashIDs{}.Next("https://example.com/")
+	saveTestLink(t, hash, "https://example.com/")
+	got, err := existingLink(r, &Link{Hash: hash, Destination: "https://example.com/"}, false)
+	if err != nil || got == nil {
+		t.Errorf("existingLink() = %v, %v, want the link", got, err)
+	}
+	_, err = existingLink(r, &Link{Hash: hash, Destination: "https://example.com/", MaxClicks: 3}, false)
+	if err != errCodeTaken {
+		t.Errorf("existingLink() with other settings = %v, want errCodeTaken", err)
+	}
+
+	// the link was edited to go somewhere else since
+	err = store.SetDestination(hash, "https://example.com/elsewhere")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = existingLink(r, &Link{Hash: hash, Destination: "https://example.com/"}, false)
+	if err != errCodeTaken {
+		t.Errorf("existingLink() after an edit = %v, want errCodeTaken", err)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+// httpsOnly rejects plain http:// destinations when creating links and
+// refuses to redirect to them from links made before it was turned on
+var httpsOnly bool
+
+// canonicalHosts lowercases destination hosts and drops default ports, so
+// that the same page always gets the same link
+var canonicalHosts = true
+
+var extraSlashes = regexp.MustCompile(`(?i)^(https?:)/{3,}`)
+
+var errHTTPSRequired = errors.New("this server only links to https:// destinations")
+
+var schemePrefix = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):[^0-9]`)
+
+// normalizeDestination checks a destination the way it's typed into the
+// create form and returns the URL we'd actually store, along with warnings
+// about anything we changed
+func normalizeDestination(raw string) (string, []string, error) {
+	var warnings []string
+
+	destination := strings.TrimSpace(raw)
+	if destination != raw {
+		warnings = append(warnings, "removed surrounding whitespace")
+	}
+	if destination == "" {
+		return "", warnings, errors.New("destination is empty")
+	}
+
+	// "example.com:8080/x" has a port, but "javascript:..." has a scheme
+	scheme := schemePrefix.FindStringSubmatch(destination)
+	if scheme == nil {
+		destination = "https://" + destination
+		warnings = append(warnings, "added https://")
+	} else if s := strings.ToLower(scheme[1]); s != "http" && s != "https" {
+		return "", warnings, errors.New("only http:// and https:// links are allowed, not " + s + ":")
+	}
+
+	if canonicalHosts && extraSlashes.MatchString(destination) {
+		destination = extraSlashes.ReplaceAllString(destination, "$1//")
+		warnings = append(warnings, "removed extra slashes after the scheme")
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		return "", warnings, errors.New("destination isn't a valid URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", warnings, errors.New("only http:// and https:// links are allowed, not " + u.Scheme + ":")
+	}
+	if u.Host == "" {
+		return "", warnings, errors.New("destination has no host, like example.com")
+	}
+	if httpsOnly && u.Scheme != "https" {
+		return "", warnings, errHTTPSRequired
+	}
+
+	if canonicalHosts {
+		changes, err := canonicalizeHost(u)
+		warnings = append(warnings, changes...)
+		if err != nil {
+			return "", warnings, err
+		}
+	}
+	err = checkDestinationHost(u)
+	if err != nil {
+		return "", warnings, err
+	}
+
+	return u.String(), warnings, nil
+}
+
+// hostProfile is IDNA's lookup profile, except that it allows underscores,
+// which show up in real hostnames even though they aren't strictly valid
+var hostProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+// canonicalizeHost lowercases u's host, converts international domain
+// names to their ASCII form and removes the port if it's the default for
+// the scheme, leaving the path and query alone
+func canonicalizeHost(u *url.URL) ([]string, error) {
+	var warnings []string
+
+	host, port := u.Hostname(), u.Port()
+	if lower := strings.ToLower(host); lower != host {
+		host = lower
+		warnings = append(warnings, "lowercased the host")
+	}
+	if !strings.Contains(host, ":") {
+		ascii, err := hostProfile.ToASCII(host)
+		if err != nil {
+			return warnings, errors.New("destination host isn't a valid domain name")
+		}
+		if ascii != host {
+			host = ascii
+			warnings = append(warnings, "converted the host to its ASCII form")
+		}
+	}
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+		warnings = append(warnings, "removed the default port")
+	}
+
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]" // IPv6
+	default:
+		u.Host = host
+	}
+	return warnings, nil
+}
+
+// redirectCheckClient asks destinations whether they redirect. Anyone can
+// have it fetch a URL, so it only talks to public addresses.
+var redirectCheckClient = func() *http.Client {
+	c := newPublicClient(5 * time.Second)
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}()
+
+// redirectWarning returns a warning if the destination itself redirects
+// somewhere else, or "" if it doesn't (or can't be reached, or isn't on
+// the public internet)
+func redirectWarning(ctx context.Context, destination string) string {
+	u, err := url.Parse(destination)
+	if err != nil || checkPublicHost(ctx, u.Hostname()) != nil {
+		return ""
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, destination, nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := redirectCheckClient.Do(req)
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		return "destination redirects to " + resp.Header.Get("Location")
+	}
+	return ""
+}
+
+type validation struct {
+	Valid      bool     `json:"valid"`
+	Normalized string   `json:"normalized,omitempty"`
+	Warnings   []string `json:"warnings"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// validateHandler serves POST /api/validate, which runs the same checks as
+// creating a link without saving anything. The destination can be sent as
+// a form value or as {"destination": "..."}; with check_redirect=true we
+// also ask the destination whether it redirects.
+func validateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "only POST is supported")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+
+	var body struct {
+		Destination   string `json:"destination"`
+		CheckRedirect bool   `json:"check_redirect"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+	} else {
+		body.Destination = r.FormValue("destination")
+		body.CheckRedirect = r.FormValue("check_redirect") == "true"
+	}
+
+	v := validation{Warnings: []string{}}
+	normalized, warnings, err := normalizeDestination(body.Destination)
+	v.Warnings = append(v.Warnings, warnings...)
+	if err != nil {
+		v.Error = err.Error()
+		writeJSON(w, http.StatusOK, v)
+		return
+	}
+
+	v.Valid = true
+	v.Normalized = normalized
+	if body.CheckRedirect {
+		if warning := redirectWarning(r.Context(), normalized); warning != "" {
+			v.Warnings = append(v.Warnings, warning)
+		}
+	}
+	writeJSON(w, http.StatusOK, v)
+}
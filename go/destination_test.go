@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPSOnlyDestinations(t *testing.T) {
+	tests := []struct {
+		raw       string
+		httpsOnly bool
+		want      string
+		wantErr   bool
+	}{
+		{raw: "https://example.com/a", want: "https://example.com/a"},
+		{raw: "http://example.com/a", want: "http://example.com/a"},
+		{raw: "example.com/a", want: "https://example.com/a"},
+		{raw: "https://example.com/a", httpsOnly: true, want: "https://example.com/a"},
+		{raw: "http://example.com/a", httpsOnly: true, wantErr: true},
+		{raw: "HTTP://example.com/a", httpsOnly: true, wantErr: true},
+		{raw: "example.com/a", httpsOnly: true, want: "https://example.com/a"},
+	}
+	for _, tt := range tests {
+		old := httpsOnly
+		httpsOnly = tt.httpsOnly
+		got, _, err := normalizeDestination(tt.raw)
+		httpsOnly = old
+
+		if tt.wantErr {
+			if err != errHTTPSRequired {
+				t.Errorf("normalizeDestination(%q) with https only = %q, %v, want errHTTPSRequired", tt.raw, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeDestination(%q) with https only %v = %q, %v, want %q", tt.raw, tt.httpsOnly, got, err, tt.want)
+		}
+	}
+}
+
+// links made before -https-only-destinations was turned on keep working
+// until it is, and then stop redirecting
+func TestHTTPSOnlyRedirects(t *testing.T) {
+	tests := []struct {
+		destination string
+		httpsOnly   bool
+		want        int
+	}{
+		{destination: "http://example.com/old", want: http.StatusFound},
+		{destination: "https://example.com/new", want: http.StatusFound},
+		{destination: "http://example.com/old", httpsOnly: true, want: http.StatusForbidden},
+		{destination: "https://example.com/new", httpsOnly: true, want: http.StatusFound},
+	}
+	for _, tt := range tests {
+		inTempDir(t)
+		saveTestLink(t, "abc1234", tt.destination)
+		old := httpsOnly
+		httpsOnly = tt.httpsOnly
+
+		w := httptest.NewRecorder()
+		goHandler(w, httptest.NewRequest(http.MethodGet, "/go/abc1234", nil), "abc1234")
+		httpsOnly = old
+
+		if w.Code != tt.want {
+			t.Errorf("click on a link to %s with https only %v = %d, want %d", tt.destination, tt.httpsOnly, w.Code, tt.want)
+		}
+		if loc := w.Header().Get("Location"); tt.want == http.StatusFound && loc != tt.destination {
+			t.Errorf("click on a link to %s went to %q", tt.destination, loc)
+		}
+
+		// a refused click isn't counted
+		hits, err := store.LoadHits("abc1234")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counted := len(hits) > 0; counted != (tt.want == http.StatusFound) {
+			t.Errorf("click on a link to %s with https only %v recorded %d hits", tt.destination, tt.httpsOnly, len(hits))
+		}
+	}
+}
+
+func TestCanonicalHosts(t *testing.T) {
+	tests := []struct {
+		raw       string
+		canonical bool
+		want      string
+	}{
+		{raw: "https://Example.COM/Path", canonical: true, want: "https://example.com/Path"},
+		{raw: "https://example.com:443/a", canonical: true, want: "https://example.com/a"},
+		{raw: "http://example.com:80/a", canonical: true, want: "http://example.com/a"},
+		{raw: "https://example.com:80/a", canonical: true, want: "https://example.com:80/a"},
+		{raw: "http://example.com:443/a", canonical: true, want: "http://example.com:443/a"},
+		{raw: "https://EXAMPLE.com:8443/a", canonical: true, want: "https://example.com:8443/a"},
+		{raw: "https:///example.com/a", canonical: true, want: "https://example.com/a"},
+		{raw: "HTTPS:////Example.com:443//a//b", canonical: true, want: "https://example.com//a//b"},
+		{raw: "https://[2001:DB8::1]:443/a", canonical: true, want: "https://[2001:db8::1]/a"},
+		{raw: "https://[2001:db8::1]:8443/a", canonical: true, want: "https://[2001:db8::1]:8443/a"},
+		{raw: "https://bücher.example/a", canonical: true, want: "https://xn--bcher-kva.example/a"},
+		{raw: "https://my_host.example.com/a", canonical: true, want: "https://my_host.example.com/a"},
+		{raw: "https://example.com/a?Q=B#Frag", canonical: true, want: "https://example.com/a?Q=B#Frag"},
+		{raw: "https://Example.COM:443/Path", canonical: false, want: "https://Example.COM:443/Path"},
+	}
+	for _, tt := range tests {
+		old := canonicalHosts
+		canonicalHosts = tt.canonical
+		got, _, err := normalizeDestination(tt.raw)
+		canonicalHosts = old
+
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeDestination(%q) = %q, %v, want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+// the same page typed differently gets one link
+func TestCanonicalHostsAgree(t *testing.T) {
+	spellings := []string{
+		"https://example.com/page",
+		"https://EXAMPLE.com/page",
+		"https://example.com:443/page",
+		"https:///Example.Com:443/page",
+		"  example.com/page ",
+	}
+	for _, raw := range spellings {
+		got, _, err := normalizeDestination(raw)
+		if err != nil || got != spellings[0] {
+			t.Errorf("normalizeDestination(%q) = %q, %v, want %q", raw, got, err, spellings[0])
+		}
+	}
+}
+
+func TestCanonicalHostsWarn(t *testing.T) {
+	_, warnings, err := normalizeDestination("https:///EXAMPLE.com:443/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"removed extra slashes after the scheme", "lowercased the host", "removed the default port"}
+	if len(warnings) != len(want) {
+		t.Fatalf("warnings = %q, want %q", warnings, want)
+	}
+	for i := range want {
+		if warnings[i] != want[i] {
+			t.Errorf("warnings = %q, want %q", warnings, want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+)
+
+// setDestination repoints a link, keeping its code and hits
+func setDestination(hash, destination string) error {
+	err := store.SetDestination(hash, destination)
+	if err != nil {
+		return err
+	}
+	invalidateSummary(hash)
+	return nil
+}
+
+// editHandler shows the edit form on GET /edit/<hash> and saves it on POST
+func editHandler(w http.ResponseWriter, r *http.Request, m string) {
+	l, err := store.LoadLink(m)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		err = templates.ExecuteTemplate(w, "edit.html", l)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	destination, _, err := normalizeDestination(r.FormValue("destination"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tags := parseTags(r.FormValue("tags"))
+	title, notes := r.FormValue("title"), r.FormValue("notes")
+	u := &linkUpdate{Tags: &tags, Title: &title, Notes: &notes}
+	err = u.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = updateLink(m, u)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = setDestination(m, destination)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("audit: %s repointed link %s from %s to %s", r.RemoteAddr, m, l.Destination, destination)
+	http.Redirect(w, r, appPath("/analytics/"+m), http.StatusSeeOther)
+}
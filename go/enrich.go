@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// An enrichment is an optional way of classifying hits, like by country
+// with a GeoIP database or by device from the user agent. Any of them can
+// be missing or broken without the analytics page failing: its breakdown
+// is just marked unavailable.
+type enrichment struct {
+	name string
+	err  error              // why the enrichment can't be used, if it can't
+	key  func(h Hit) string // the bucket a hit falls in, "" if unknown
+}
+
+var errNoGeoIP = errors.New("no GeoIP database configured")
+
+// enrichments is every breakdown shown on the analytics page, in order
+var enrichments = []*enrichment{
+	{name: "countries", err: errNoGeoIP, key: func(h Hit) string { return h.Country }},
+	{name: "cities", err: errNoGeoIP, key: func(h Hit) string { return h.City }},
+	{name: "browsers", key: func(h Hit) string { return parseUserAgent(h.UserAgent).Browser }},
+	{name: "operating systems", key: func(h Hit) string { return parseUserAgent(h.UserAgent).OS }},
+	{name: "devices", key: func(h Hit) string { return parseUserAgent(h.UserAgent).Device }},
+	{name: "referrers", key: func(h Hit) string { return referrerSite(h.Referrer) }},
+	{name: "sources", key: func(h Hit) string { return h.Source }},
+	{name: "campaign / source / medium", key: func(h Hit) string { return h.campaign() }},
+	{name: "methods", key: func(h Hit) string { return h.Method }},
+	{name: "new vs returning", key: func(h Hit) string { return h.Visit }},
+	{name: "TLS versions", err: errNoTLSRecording, key: func(h Hit) string { return h.TLSVersion }},
+	{name: "destinations", key: func(h Hit) string { return h.Destination }},
+	{name: "A/B variants", key: func(h Hit) string { return h.variantName() }},
+	{name: "geo rules", err: errNoGeoIP, key: func(h Hit) string { return h.geoRuleName() }},
+	{name: "pages", key: func(h Hit) string { return h.Page }},
+	{name: "languages", key: func(h Hit) string { return h.Language }},
+	{name: "screen sizes", key: func(h Hit) string { return h.Screen }},
+}
+
+var errNoTLSRecording = errors.New("not recorded without -record-tls")
+
+func enrichmentNamed(name string) *enrichment {
+	for _, e := range enrichments {
+		if e.name == name {
+			return e
+		}
+	}
+	panic("no enrichment named " + name)
+}
+
+type Count struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// A Breakdown is how a link's hits split up by one enrichment
+type Breakdown struct {
+	Name      string  `json:"name"`
+	Available bool    `json:"available"`
+	Reason    string  `json:"reason,omitempty"` // why it isn't available
+	Rows      []Count `json:"rows,omitempty"`
+}
+
+// breakdown never fails: a missing enrichment or one that panics on odd
+// input just gives an unavailable Breakdown
+func (e *enrichment) breakdown(hits []Hit) (b Breakdown) {
+	b.Name = e.name
+	if e.err != nil {
+		b.Reason = e.err.Error()
+		return b
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			b = Breakdown{Name: e.name, Reason: fmt.Sprint("failed: ", r)}
+		}
+	}()
+
+	counts := map[string]int{}
+	for _, h := range hits {
+		key := e.key(h)
+		if key == "" {
+			key = "unknown"
+		}
+		counts[key]++
+	}
+	for key, count := range counts {
+		b.Rows = append(b.Rows, Count{key, count})
+	}
+	sort.Slice(b.Rows, func(i, j int) bool {
+		if b.Rows[i].Count != b.Rows[j].Count {
+			return b.Rows[i].Count > b.Rows[j].Count
+		}
+		return b.Rows[i].Key < b.Rows[j].Key
+	})
+
+	b.Available = true
+	return b
+}
+
+func breakdowns(hits []Hit) []Breakdown {
+	var bs []Breakdown
+	for _, e := range enrichments {
+		bs = append(bs, e.breakdown(hits))
+	}
+	return bs
+}
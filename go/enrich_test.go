@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var enrichTestHits = []Hit{
+	{UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36", Referrer: "https://news.example.com/a"},
+	{UserAgent: "", Referrer: "not a url at all %%"},
+	{UserAgent: "\x00\xff garbage"},
+}
+
+func TestBreakdownsWithoutGeoIP(t *testing.T) {
+	if geoDB != nil {
+		t.Skip("a GeoIP database is open")
+	}
+	geo := map[string]bool{"countries": true, "cities": true, "geo rules": true}
+	for _, b := range breakdowns(enrichTestHits) {
+		if geo[b.Name] {
+			if b.Available || b.Reason != errNoGeoIP.Error() || len(b.Rows) > 0 {
+				t.Errorf("%s = %+v, want unavailable for lack of GeoIP", b.Name, b)
+			}
+			continue
+		}
+		if b.Name == "TLS versions" {
+			continue
+		}
+		if !b.Available {
+			t.Errorf("%s is unavailable (%s) without GeoIP", b.Name, b.Reason)
+			continue
+		}
+		total := 0
+		for _, row := range b.Rows {
+			total += row.Count
+		}
+		if total != len(enrichTestHits) {
+			t.Errorf("%s counts %d hits, want %d", b.Name, total, len(enrichTestHits))
+		}
+	}
+}
+
+func TestBrokenGeoIPDatabase(t *testing.T) {
+	if geoDB != nil {
+		t.Skip("a GeoIP database is open")
+	}
+	path := filepath.Join(t.TempDir(), "broken.mmdb")
+	err := os.WriteFile(path, []byte("this isn't a MaxMind database"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path, filepath.Join(t.TempDir(), "missing.mmdb")} {
+		if err := openGeoIP(p); err == nil {
+			t.Errorf("openGeoIP(%s) succeeded", filepath.Base(p))
+		}
+	}
+	if geoDB != nil || enrichmentNamed("countries").err == nil {
+		t.Error("a database that didn't open turned on the GeoIP breakdowns")
+	}
+	for _, ip := range []string{"203.0.113.7", "2001:db8::1", "not an ip", ""} {
+		if country, city := locate(ip); country != "" || city != "" {
+			t.Errorf("locate(%q) = %q, %q without a database", ip, country, city)
+		}
+	}
+}
+
+func TestBreakdownSurvivesPanics(t *testing.T) {
+	e := &enrichment{name: "fragile", key: func(h Hit) string {
+		if h.UserAgent == "" {
+			panic("can't parse an empty user agent")
+		}
+		return "fine"
+	}}
+	b := e.breakdown(enrichTestHits)
+	if b.Available || len(b.Rows) > 0 {
+		t.Errorf("breakdown = %+v, want unavailable", b)
+	}
+	if b.Name != "fragile" || !strings.HasPrefix(b.Reason, "failed: ") {
+		t.Errorf("breakdown = %+v, want a failure reason", b)
+	}
+
+	b = e.breakdown(enrichTestHits[:1])
+	if !b.Available || len(b.Rows) != 1 || b.Rows[0] != (Count{"fine", 1}) {
+		t.Errorf("breakdown of hits it can parse = %+v", b)
+	}
+}
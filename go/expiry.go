@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// A Duration is a time.Duration that's stored as a string like "720h"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// expiredMessage is shown on the page people get from an expired link
+var expiredMessage = "This link has expired."
+
+// Expired reports whether a link is past its Expires time
+func (l *Link) Expired() bool {
+	return !l.Expires.IsZero() && time.Now().After(l.Expires)
+}
+
+// serveExpired answers a click on a link that's expired or used up with a
+// 410 page
+func serveExpired(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusGone)
+	templates.ExecuteTemplate(w, "expired.html", message)
+}
+
+// IdleExpiry is when a link with an IdleTTL stops working unless it's
+// clicked again before then
+func (l *Link) IdleExpiry() time.Time {
+	return l.LastActive.Add(time.Duration(l.IdleTTL))
+}
+
+// errIdleAndFixedExpiry is returned when a link would get both an IdleTTL and
+// an Expires time, since it's unclear which one should win
+var errIdleAndFixedExpiry = errors.New("a link can expire at a fixed time (expires or expires_in) or after going unused (idle_ttl), not both")
+
+// lapsed reports whether a link has gone unused for longer than its IdleTTL
+func (l *Link) lapsed() bool {
+	return l.IdleTTL > 0 && time.Now().After(l.IdleExpiry())
+}
+
+// sweepLapsed deletes every link whose IdleTTL has run out, every interval
+// until ctx is done
+func sweepLapsed(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		hashes, err := store.ListLinks()
+		if err != nil {
+			log.Print("sweep: ", err)
+			continue
+		}
+		for _, hash := range hashes {
+			l, err := store.LoadLink(hash)
+			if err != nil || !l.lapsed() {
+				continue
+			}
+			err = store.DeleteLink(hash)
+			if err != nil {
+				log.Print("sweep: ", err)
+				continue
+			}
+			invalidateSummary(l.Hash)
+			log.Printf("sweep: removed %s, unused since %s", l.Hash, l.LastActive.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// exportVersion is bumped whenever the archive format changes in a way
+// older importers can't read
+const exportVersion = 1
+
+// An exportedLink is a link with everything needed to recreate it in any
+// Store. Link's own fields are flattened in next to these.
+type exportedLink struct {
+	Hash        string `json:"hash"`
+	Destination string `json:"destination"`
+	Workspace   string `json:"workspace,omitempty"`
+	*Link
+	Hits []Hit `json:"hits"`
+}
+
+type archive struct {
+	Version  int            `json:"version"`
+	Exported time.Time      `json:"exported"`
+	Links    []exportedLink `json:"links"`
+}
+
+// exportAll writes every link and its hits as one JSON archive. Links are
+// written one at a time so the whole store never has to be in memory.
+// Destinations and hits are written decrypted.
+func exportAll(w io.Writer) error {
+	hashes, err := store.ListLinks()
+	if err != nil {
+		return err
+	}
+	sort.Strings(hashes)
+
+	_, err = fmt.Fprintf(w, `{"version":%d,"exported":%q,"links":[`, exportVersion, time.Now().Format(time.RFC3339Nano))
+	if err != nil {
+		return err
+	}
+	for i, hash := range hashes {
+		l, err := store.LoadLink(hash)
+		if err != nil {
+			return fmt.Errorf("%s: %w", hash, err)
+		}
+		hits, err := store.LoadHits(hash)
+		if err != nil {
+			return fmt.Errorf("%s: %w", hash, err)
+		}
+		if hits == nil {
+			hits = []Hit{}
+		}
+		line, err := json.Marshal(exportedLink{l.Hash, l.Destination, l.Workspace, l, hits})
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			line = append([]byte{','}, line...)
+		}
+		_, err = w.Write(append(line, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// importAll adds the links in an archive to the store. Links whose hash is
+// already taken are skipped rather than merged, so importing the same
+// archive twice doesn't double its hits.
+func importAll(r io.Reader) (imported, skipped int, err error) {
+	var a archive
+	err = json.NewDecoder(r).Decode(&a)
+	if err != nil {
+		return 0, 0, err
+	}
+	if a.Version != exportVersion {
+		return 0, 0, fmt.Errorf("can't import archive version %d (want %d)", a.Version, exportVersion)
+	}
+
+	for _, e := range a.Links {
+		if !validHash.MatchString(e.Hash) {
+			return imported, skipped, fmt.Errorf("invalid hash %q", e.Hash)
+		}
+		if _, err := store.LoadLink(e.Hash); err == nil {
+			skipped++
+			continue
+		} else if !os.IsNotExist(err) {
+			return imported, skipped, err
+		}
+
+		l := e.Link
+		if l == nil {
+			l = &Link{}
+		}
+		l.Hash, l.Destination, l.Workspace = e.Hash, e.Destination, e.Workspace
+		err = store.SaveLink(l)
+		if err != nil {
+			return imported, skipped, fmt.Errorf("%s: %w", e.Hash, err)
+		}
+		for _, h := range e.Hits {
+			err = store.AppendHit(e.Hash, h)
+			if err != nil {
+				return imported, skipped, fmt.Errorf("%s: %w", e.Hash, err)
+			}
+		}
+		invalidateSummary(e.Hash)
+		imported++
+	}
+	return imported, skipped, nil
+}
+
+// exportHandler serves GET /api/v1/export, the archive -export writes
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		apiError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="linkanalytics-`+time.Now().Format("2006-01-02")+`.json"`)
+	err := exportAll(w)
+	if err != nil {
+		// the status is already sent, so all we can do is cut the
+		//	archive short, which leaves it invalid JSON
+		log.Print("export: ", err)
+	}
+}
+
+// importHandler serves POST /api/v1/import, which takes an archive as the
+// request body
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		apiError(w, http.StatusMethodNotAllowed, "only POST is supported")
+		return
+	}
+	imported, skipped, err := importAll(r.Body)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"imported": imported, "skipped": skipped})
+}
+
+// runExport and runImport back the -export and -import flags, which take a
+// file name or - for stdout or stdin
+func runExport(path string) error {
+	if path == "-" {
+		return exportAll(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = exportAll(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runImport(path string) error {
+	in := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	imported, skipped, err := importAll(in)
+	log.Printf("imported %d links, skipped %d that already existed", imported, skipped)
+	return err
+}
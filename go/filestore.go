@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileStore keeps each link in a "<hash>.linkanalytics" file, in a shard
+// directory named after the start of its hash so no one directory gets too
+// big. Links in a workspace have their shard directories in a subdirectory
+// named after it: "_ab/abc123.linkanalytics" or "ws/_ab/abc123.linkanalytics".
+// Shard directories start with an underscore, which workspace names can't.
+//
+// The file starts with the destination on the first line; anything else
+// we know about the link is stored as JSON on a "meta: " line right after
+// it. Hits are appended as "hit: " lines to a "<hash>.hits" file next to
+// it, so recording a click never touches the file that's rewritten when
+// the link changes. (Links from before that have their older hits after
+// the meta line.) The later of the two files' modification times doubles
+// as the link's LastActive.
+//
+// Changes to a link are serialized with linkLocks, so the file store
+// expects to be the only process using its directory.
+type fileStore struct{}
+
+// linkLocks serialize writes to a link's files. Links share a fixed set of
+// locks rather than each getting their own, so the set never grows.
+var linkLocks [64]sync.Mutex
+
+func linkLock(hash string) int {
+	h := fnv.New32a()
+	h.Write([]byte(hash))
+	return int(h.Sum32() % uint32(len(linkLocks)))
+}
+
+// lockLinks takes the locks for every hash, in a fixed order so two callers
+// can't deadlock, and returns a function that releases them
+func lockLinks(hashes ...string) func() {
+	var locks []int
+	for _, hash := range hashes {
+		locks = append(locks, linkLock(hash))
+	}
+	sort.Ints(locks)
+	for i, l := range locks {
+		if i == 0 || l != locks[i-1] {
+			linkLocks[l].Lock()
+		}
+	}
+	return func() {
+		for i, l := range locks {
+			if i == 0 || l != locks[i-1] {
+				linkLocks[l].Unlock()
+			}
+		}
+	}
+}
+
+// hitsPath is the hit log that goes with a link file
+func hitsPath(filename string) string {
+	return strings.TrimSuffix(filename, ".linkanalytics") + ".hits"
+}
+
+// moveHits moves a link's hit log along with it, if it has one
+func moveHits(from, to string) error {
+	err := os.Rename(hitsPath(from), hitsPath(to))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// shardOf is the directory a hash's file goes in. Shards are lowercase so
+// they work the same on case-insensitive filesystems.
+func shardOf(hash string) string {
+	if len(hash) > 2 {
+		hash = hash[:2]
+	}
+	return "_" + strings.ToLower(hash)
+}
+
+// linkPath is where a link's file goes
+func linkPath(workspace, hash string) string {
+	return filepath.Join(workspace, shardOf(hash), hash+".linkanalytics")
+}
+
+// workspaceOf is the workspace a link's file is in, "" for none
+func workspaceOf(filename string) string {
+	dir := filepath.Dir(filename)
+	if strings.HasPrefix(filepath.Base(dir), "_") {
+		dir = filepath.Dir(dir)
+	}
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// linkFilename finds the file for a hash, looking in the default namespace
+// first and then in every workspace. Files from before sharding are found
+// too, until shardLinkFiles moves them.
+func linkFilename(hash string) (string, error) {
+	shard := shardOf(hash)
+	filename := hash + ".linkanalytics"
+	for _, pattern := range []string{
+		filepath.Join(shard, filename),
+		filepath.Join("*", shard, filename),
+		filename,
+		filepath.Join("*", filename),
+	} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return "", err
+		}
+		if len(matches) > 0 {
+			return matches[0], nil
+		}
+	}
+	return "", &fs.PathError{Op: "open", Path: filename, Err: fs.ErrNotExist}
+}
+
+// linkFiles lists the files of every link in every namespace
+func linkFiles() ([]string, error) {
+	var files []string
+	for _, pattern := range []string{
+		filepath.Join("_*", "*.linkanalytics"),
+		filepath.Join("*", "_*", "*.linkanalytics"),
+		"*.linkanalytics",
+		filepath.Join("*", "*.linkanalytics"),
+	} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			// the last pattern matches sharded files without a
+			//	workspace too
+			if pattern == filepath.Join("*", "*.linkanalytics") && strings.HasPrefix(match, "_") {
+				continue
+			}
+			files = append(files, match)
+		}
+	}
+	return files, nil
+}
+
+// shardLinkFiles moves link files from before sharding into their shards
+func shardLinkFiles() (int, error) {
+	files, err := linkFiles()
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, filename := range files {
+		target := linkPath(workspaceOf(filename), hashOf(filename))
+		if filename == target {
+			continue
+		}
+		err = os.MkdirAll(filepath.Dir(target), 0700)
+		if err != nil {
+			return moved, err
+		}
+		err = os.Rename(filename, target)
+		if err != nil {
+			return moved, err
+		}
+		err = moveHits(filename, target)
+		if err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+func hashOf(filename string) string {
+	return strings.TrimSuffix(filepath.Base(filename), ".linkanalytics")
+}
+
+func (s fileStore) SaveLink(l *Link) error {
+	return s.save(l, false)
+}
+
+func (s fileStore) CreateLink(l *Link) error {
+	return s.save(l, true)
+}
+
+// save writes l, refusing to if create is set and it already exists
+func (fileStore) save(l *Link, create bool) error {
+	if l.Workspace != "" && !validWorkspace.MatchString(l.Workspace) {
+		return fmt.Errorf("invalid workspace %q", l.Workspace)
+	}
+	defer lockLinks(l.Hash)()
+
+	filename := linkPath(l.Workspace, l.Hash)
+	err := os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+	// saving a link that already exists keeps its hits and creation time,
+	//	and saving over a different link is refused so a collision can never
+	//	lose data
+	var hits []byte
+	existing, err := linkFilename(l.Hash)
+	if err == nil && create {
+		return errCodeTaken
+	} else if err == nil {
+		old, err := os.ReadFile(existing)
+		if err != nil {
+			return err
+		}
+		oldLine, rest, _ := bytes.Cut(old, []byte("\n"))
+		oldDestination, err := unseal(string(oldLine))
+		if err != nil {
+			return err
+		}
+		if oldDestination != l.Destination {
+			return errCodeTaken
+		}
+		if oldMeta, found := bytes.CutPrefix(rest, []byte("meta: ")); found {
+			oldMeta, rest, _ = bytes.Cut(oldMeta, []byte("\n"))
+			var oldLink Link
+			if json.Unmarshal(oldMeta, &oldLink) == nil && !oldLink.Created.IsZero() {
+				l.Created = oldLink.Created
+			}
+		}
+		hits = rest
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	destination := l.Destination
+	if atRest != nil {
+		destination, err = seal(destination)
+		if err != nil {
+			return err
+		}
+	}
+	contents := []byte(destination + "\n")
+	meta, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if string(meta) != "{}" {
+		contents = append(contents, "meta: "+string(meta)+"\n"...)
+	}
+	contents = append(contents, hits...)
+
+	// write to a temporary file first so a failed write can't leave a link
+	//	half saved
+	temp := filename + ".tmp"
+	err = os.WriteFile(temp, contents, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temp, filename)
+	if err != nil {
+		return err
+	}
+
+	// the link moved to a different workspace, or out of the flat layout
+	if existing != "" && existing != filename {
+		err = moveHits(existing, filename)
+		if err != nil {
+			return err
+		}
+		return os.Remove(existing)
+	}
+	return nil
+}
+
+func (fileStore) LoadLink(hash string) (*Link, error) {
+	filename, err := linkFilename(hash)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(file)
+
+	// only the first line is the destination
+	scanner.Scan()
+	destination, err := unseal(scanner.Text())
+	if err != nil {
+		return nil, err
+	}
+	l := &Link{Destination: destination, Hash: hash, LastActive: info.ModTime()}
+	if hitsInfo, err := os.Stat(hitsPath(filename)); err == nil && hitsInfo.ModTime().After(l.LastActive) {
+		l.LastActive = hitsInfo.ModTime()
+	}
+	l.Workspace = workspaceOf(filename)
+
+	// older links don't have a meta line, so the next line may be a hit
+	scanner.Scan()
+	meta, found := strings.CutPrefix(scanner.Text(), "meta: ")
+	if found {
+		err = json.Unmarshal([]byte(meta), l)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+func (fileStore) AppendHit(hash string, h Hit) error {
+	line, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	if encryptHits && atRest != nil {
+		sealed, err := seal(string(line))
+		if err != nil {
+			return err
+		}
+		line = []byte(sealed)
+	}
+
+	defer lockLinks(hash)()
+	filename, err := linkFilename(hash)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(hitsPath(filename), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	// the whole line goes in one write, so even a reader that doesn't
+	//	take the lock never sees half of one
+	_, err = file.Write(append([]byte("hit: "), append(line, '\n')...))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// hits recorded before they were JSON came from a log.Logger, so they look
+// like "hit: 2006/01/02 15:04:05 <user agent>"
+const hitTimeLayout = "2006/01/02 15:04:05"
+
+func parseHit(line string) (Hit, bool) {
+	rest, found := strings.CutPrefix(line, "hit: ")
+	if !found {
+		return Hit{}, false
+	}
+
+	if strings.HasPrefix(rest, "{") {
+		var h Hit
+		err := json.Unmarshal([]byte(rest), &h)
+		return h, err == nil
+	}
+
+	if len(rest) < len(hitTimeLayout) {
+		return Hit{}, false
+	}
+	t, err := time.ParseInLocation(hitTimeLayout, rest[:len(hitTimeLayout)], time.Local)
+	if err != nil {
+		return Hit{}, false
+	}
+	return Hit{Time: t, UserAgent: strings.TrimSpace(rest[len(hitTimeLayout):])}, true
+}
+
+func (fileStore) LoadHits(hash string) ([]Hit, error) {
+	filename, err := linkFilename(hash)
+	if err != nil {
+		return nil, err
+	}
+	// older hits come first, from before they had a file of their own
+	hits, err := readHits(filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	hits, err = readHits(hitsPath(filename), hits)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return hits, err
+}
+
+// readHits appends the hits on the "hit: " lines of a file to hits
+func readHits(filename string, hits []Hit) ([]Hit, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return hits, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, err := unsealHitLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		h, ok := parseHit(line)
+		if ok {
+			hits = append(hits, h)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+func (fileStore) ListLinks() ([]string, error) {
+	filenames, err := linkFiles()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(filenames))
+	for i, filename := range filenames {
+		hashes[i] = hashOf(filename)
+	}
+	return hashes, nil
+}
+
+func (fileStore) DeleteLink(hash string) error {
+	defer lockLinks(hash)()
+	filename, err := linkFilename(hash)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filename)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(hitsPath(filename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// RenameLink uses os.Link, which unlike os.Rename refuses to replace an
+// existing file, so the link can't land on top of another one
+func (fileStore) RenameLink(hash, code string) error {
+	defer lockLinks(hash, code)()
+	oldFilename, err := linkFilename(hash)
+	if err != nil {
+		return err
+	}
+	_, err = linkFilename(code)
+	if err == nil {
+		return fs.ErrExist
+	}
+
+	newFilename := linkPath(workspaceOf(oldFilename), code)
+	err = os.MkdirAll(filepath.Dir(newFilename), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.Link(oldFilename, newFilename)
+	if err != nil {
+		return err
+	}
+	err = moveHits(oldFilename, newFilename)
+	if err != nil {
+		return err
+	}
+	return os.Remove(oldFilename)
+}
+
+func (fileStore) SetDestination(hash, destination string) error {
+	defer lockLinks(hash)()
+	filename, err := linkFilename(hash)
+	if err != nil {
+		return err
+	}
+	old, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	destination, err = sealed(destination)
+	if err != nil {
+		return err
+	}
+
+	// only the first line changes; the metadata and any older hits are
+	//	kept as is
+	_, rest, _ := bytes.Cut(old, []byte("\n"))
+	contents := append([]byte(destination+"\n"), rest...)
+	temp := filename + ".tmp"
+	err = os.WriteFile(temp, contents, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp, filename)
+}
+
+// Writable creates and removes a scratch file where links are kept
+func (fileStore) Writable() error {
+	f, err := os.CreateTemp(".", ".writable-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Close has nothing to do: every write is finished before it returns
+func (fileStore) Close() error {
+	return nil
+}
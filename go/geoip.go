@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoDB is the MaxMind database given with -geoip-db, such as
+// GeoLite2-City.mmdb. A country database works too; hits just won't get a
+// city.
+var geoDB *maxminddb.Reader
+
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// openGeoIP loads the database and turns on the breakdowns that need it
+func openGeoIP(path string) error {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	geoDB = db
+	enrichmentNamed("countries").err = nil
+	enrichmentNamed("cities").err = nil
+	enrichmentNamed("geo rules").err = nil
+	return nil
+}
+
+// locate looks up the country code and English city name of an address.
+// Either is "" if the database doesn't know it.
+func locate(ip string) (country, city string) {
+	parsed := net.ParseIP(ip)
+	if geoDB == nil || parsed == nil {
+		return "", ""
+	}
+	var rec geoRecord
+	err := geoDB.Lookup(parsed, &rec)
+	if err != nil {
+		return "", ""
+	}
+	return rec.Country.ISOCode, rec.City.Names["en"]
+}
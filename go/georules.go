@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// A GeoRule sends visitors from some countries somewhere other than the
+// link's destination. Countries are ISO codes like "DE", or "EU" for every
+// country in the European Union.
+type GeoRule struct {
+	Countries   []string `json:"countries"`
+	Destination string   `json:"destination"`
+}
+
+// euCountries are the members of the European Union, which "EU" stands for
+// in a GeoRule
+var euCountries = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "HR": true, "CY": true, "CZ": true,
+	"DK": true, "EE": true, "FI": true, "FR": true, "DE": true, "GR": true,
+	"HU": true, "IE": true, "IT": true, "LV": true, "LT": true, "LU": true,
+	"MT": true, "NL": true, "PL": true, "PT": true, "RO": true, "SK": true,
+	"SI": true, "ES": true, "SE": true,
+}
+
+var validCountry = regexp.MustCompile("^[A-Z]{2}$")
+
+func (rule GeoRule) matches(country string) bool {
+	return countryIn(rule.Countries, country)
+}
+
+// countryIn reports whether country is one of countries, counting "EU" as
+// every member of the European Union
+func countryIn(countries []string, country string) bool {
+	if country == "" {
+		return false
+	}
+	for _, c := range countries {
+		if c == country || (c == "EU" && euCountries[country]) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateGeoRules checks a link's geo rules and normalizes them in place
+func validateGeoRules(rules []GeoRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	if geoDB == nil {
+		return errors.New("geo rules need a GeoIP database, from -geoip-db")
+	}
+	for i, rule := range rules {
+		if len(rule.Countries) == 0 {
+			return fmt.Errorf("geo rule %d has no countries", i+1)
+		}
+		for j, c := range rule.Countries {
+			c = strings.ToUpper(strings.TrimSpace(c))
+			if !validCountry.MatchString(c) {
+				return fmt.Errorf("geo rule %d: %q isn't a two letter country code", i+1, c)
+			}
+			rules[i].Countries[j] = c
+		}
+		normalized, _, err := normalizeDestination(rule.Destination)
+		if err != nil {
+			return fmt.Errorf("geo rule %d: %v", i+1, err)
+		}
+		rules[i].Destination = normalized
+	}
+	return nil
+}
+
+// parseGeoRules reads the create form's geo rules, one "<countries> <url>"
+// per line, where the countries are separated by commas
+func parseGeoRules(s string) ([]GeoRule, error) {
+	var rules []GeoRule
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		countries, destination, found := strings.Cut(line, " ")
+		if !found {
+			return nil, errors.New("geo rules go one per line, as countries and a URL like: DE,AT https://example.de")
+		}
+		rules = append(rules, GeoRule{Countries: strings.Split(countries, ","), Destination: strings.TrimSpace(destination)})
+	}
+	return rules, nil
+}
+
+// visitorCountry is where r comes from, looked up only for links with geo
+// rules to check it against
+func visitorCountry(r *http.Request, l *Link) string {
+	if len(l.GeoRules) == 0 {
+		return ""
+	}
+	country, _ := locate(clientIP(r))
+	return country
+}
+
+// geoDestination is where l's geo rules send a visitor from country, and
+// the number of the rule that did, counting from 1. The first matching
+// rule wins.
+func (l *Link) geoDestination(country string) (string, int) {
+	if country == "" {
+		return "", 0
+	}
+	for i, rule := range l.GeoRules {
+		if rule.matches(country) {
+			return rule.Destination, i + 1
+		}
+	}
+	return "", 0
+}
+
+// geoRuleName labels a hit's geo rule for the analytics breakdown
+func (h Hit) geoRuleName() string {
+	if h.GeoRule == 0 {
+		return ""
+	}
+	return fmt.Sprintf("rule %d", h.GeoRule)
+}
+
+// GeoRuleList describes l's geo rules, numbered the same way as the
+// analytics breakdown
+func (l *Link) GeoRuleList() []string {
+	var list []string
+	for i, rule := range l.GeoRules {
+		list = append(list, fmt.Sprintf("rule %d: %s to %s", i+1, strings.Join(rule.Countries, ", "), rule.Destination))
+	}
+	return list
+}
+
+// GeoRulesText is l's geo rules the way the create form takes them
+func (l *Link) GeoRulesText() string {
+	var lines []string
+	for _, rule := range l.GeoRules {
+		lines = append(lines, strings.Join(rule.Countries, ",")+" "+rule.Destination)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// goalWebhook is POSTed to when a link with a Goal reaches it
+var goalWebhook string
+
+// GoalPercent is how far a link is toward its goal, capped at 100
+func (a *LinkAnalytics) GoalPercent() int {
+	if a.GoTo.Goal <= 0 {
+		return 0
+	}
+	percent := a.Summary.Total * 100 / a.GoTo.Goal
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// checkGoal notifies goalWebhook if the click just recorded on l was the
+// one that reached its goal. Clicks arriving at the same moment can make
+// it miss the exact count, so this is best effort.
+func checkGoal(l *Link) {
+	if l.Goal <= 0 || goalWebhook == "" {
+		return
+	}
+	s, err := cachedSummary(l.Hash)
+	if err != nil || s.Total != l.Goal {
+		return
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"event":       "goal_reached",
+		"hash":        l.Hash,
+		"destination": l.Destination,
+		"goal":        l.Goal,
+		"time":        time.Now(),
+	})
+	if err != nil {
+		return
+	}
+	outbound.submit(func() {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(goalWebhook, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			log.Printf("goal webhook for %s: %v", l.Hash, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("goal webhook for %s: %s", l.Hash, resp.Status)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// The /grafana/ routes implement the SimpleJSON datasource protocol (which
+// the Infinity datasource also understands), so links can be graphed in
+// Grafana. A target is a link's hash and its datapoints are daily clicks.
+
+type grafanaTarget struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+type grafanaSeries struct {
+	Target     string     `json:"target"`
+	Datapoints [][2]int64 `json:"datapoints"` // [clicks, unix milliseconds]
+}
+
+func grafanaHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/grafana/":
+		// Grafana calls this to test the datasource
+		w.WriteHeader(http.StatusOK)
+	case "/grafana/search":
+		grafanaSearch(w, r)
+	case "/grafana/query":
+		grafanaQueryHandler(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func grafanaSearch(w http.ResponseWriter, r *http.Request) {
+	hashes, err := store.ListLinks()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	targets := []grafanaTarget{}
+	for _, hash := range hashes {
+		l, err := store.LoadLink(hash)
+		if err != nil {
+			continue
+		}
+		targets = append(targets, grafanaTarget{Text: l.Destination, Value: l.Hash})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Text < targets[j].Text })
+
+	writeJSON(w, http.StatusOK, targets)
+}
+
+func grafanaQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "queries must be POSTed")
+		return
+	}
+
+	var q grafanaQuery
+	err := json.NewDecoder(r.Body).Decode(&q)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid query: "+err.Error())
+		return
+	}
+
+	series := []grafanaSeries{}
+	for _, t := range q.Targets {
+		if !validHash.MatchString(t.Target) {
+			apiError(w, http.StatusBadRequest, "invalid target "+t.Target)
+			return
+		}
+		sum, err := cachedSummary(t.Target)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			apiError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		s := grafanaSeries{Target: t.Target, Datapoints: [][2]int64{}}
+		for _, d := range sum.Daily {
+			day, err := time.ParseInLocation("2006-01-02", d.Day, time.Local)
+			if err != nil {
+				continue
+			}
+			// keep every day that overlaps the requested range
+			if day.AddDate(0, 0, 1).Before(q.Range.From) || (!q.Range.To.IsZero() && day.After(q.Range.To)) {
+				continue
+			}
+			s.Datapoints = append(s.Datapoints, [2]int64{int64(d.Count), day.UnixMilli()})
+		}
+		series = append(series, s)
+	}
+
+	writeJSON(w, http.StatusOK, series)
+}
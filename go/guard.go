@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Hashes are the only thing keeping links and their analytics private, so
+// clients that keep asking for hashes that don't exist are probably
+// scanning for them. maxMisses is how many misses one IP gets per
+// missWindow before its lookups are refused; 0 turns the guard off.
+var (
+	maxMisses  = 30
+	missWindow = time.Minute
+)
+
+// maxMissClients is how many addresses' misses are counted at once
+const maxMissClients = 10000
+
+var misses = struct {
+	sync.Mutex
+	m map[string]*missCount
+}{m: map[string]*missCount{}}
+
+type missCount struct {
+	n     int
+	start time.Time
+}
+
+// clientIP is the address the request came from. X-Forwarded-For is only
+// believed from -trusted-proxies, since anyone scanning could set it to
+// something new each time.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return forwardedFor(r, host)
+}
+
+// allowLookup answers 429 and returns false if r's IP has missed too often
+// lately
+func allowLookup(w http.ResponseWriter, r *http.Request) bool {
+	if maxMisses <= 0 {
+		return true
+	}
+
+	misses.Lock()
+	c := misses.m[clientIP(r)]
+	blocked := c != nil && time.Since(c.start) < missWindow && c.n >= maxMisses
+	var retry time.Duration
+	if blocked {
+		retry = missWindow - time.Since(c.start)
+	}
+	misses.Unlock()
+
+	if blocked {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+		http.Error(w, "too many requests for links that don't exist", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// missedLookup counts a lookup of a hash that doesn't exist against r's IP
+func missedLookup(r *http.Request) {
+	if maxMisses <= 0 {
+		return
+	}
+
+	now := time.Now()
+	misses.Lock()
+	defer misses.Unlock()
+
+	ip := clientIP(r)
+	c := misses.m[ip]
+	if c == nil || now.Sub(c.start) >= missWindow {
+		// the map only needs to hold the current window's misses, so
+		//	it's pruned when it fills up. A scan from more addresses
+		//	than that in one window pushes out the one that's been
+		//	counting longest.
+		if c == nil && len(misses.m) >= maxMissClients {
+			oldest := ""
+			for ip, c := range misses.m {
+				if now.Sub(c.start) >= missWindow {
+					delete(misses.m, ip)
+				} else if oldest == "" || c.start.Before(misses.m[oldest].start) {
+					oldest = ip
+				}
+			}
+			if len(misses.m) >= maxMissClients {
+				delete(misses.m, oldest)
+			}
+		}
+		c = &missCount{start: now}
+		misses.m[ip] = c
+	}
+	c.n++
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func resetMisses(t *testing.T) {
+	misses.Lock()
+	misses.m = map[string]*missCount{}
+	misses.Unlock()
+	t.Cleanup(func() {
+		misses.Lock()
+		misses.m = map[string]*missCount{}
+		misses.Unlock()
+	})
+}
+
+func requestFrom(addr string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/go/nothere", nil)
+	r.RemoteAddr = addr + ":1234"
+	return r
+}
+
+func TestScanIsBlocked(t *testing.T) {
+	resetMisses(t)
+	r := requestFrom("203.0.113.7")
+	for i := 0; i < maxMisses; i++ {
+		if !allowLookup(httptest.NewRecorder(), r) {
+			t.Fatalf("blocked after %d misses", i)
+		}
+		missedLookup(r)
+	}
+	w := httptest.NewRecorder()
+	if allowLookup(w, r) || w.Code != http.StatusTooManyRequests {
+		t.Errorf("not blocked after %d misses", maxMisses)
+	}
+	if !allowLookup(httptest.NewRecorder(), requestFrom("203.0.113.8")) {
+		t.Error("another address was blocked too")
+	}
+}
+
+// a scan spread over more addresses than can be counted doesn't grow the
+// map past its limit
+func TestMissesStayBounded(t *testing.T) {
+	resetMisses(t)
+	for i := 0; i < maxMissClients+500; i++ {
+		missedLookup(requestFrom("10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256)))
+	}
+	misses.Lock()
+	n := len(misses.m)
+	_, newest := misses.m["10.0."+strconv.Itoa((maxMissClients+499)/256)+"."+strconv.Itoa((maxMissClients+499)%256)]
+	misses.Unlock()
+	if n > maxMissClients {
+		t.Errorf("counting misses for %d addresses, limit %d", n, maxMissClients)
+	}
+	if !newest {
+		t.Error("the newest address was pushed out")
+	}
+
+	// expired windows make room before anything current is pushed out
+	resetMisses(t)
+	misses.Lock()
+	for i := 0; i < maxMissClients; i++ {
+		misses.m[strconv.Itoa(i)] = &missCount{n: 1, start: time.Now().Add(-2 * missWindow)}
+	}
+	misses.Unlock()
+	missedLookup(requestFrom("203.0.113.9"))
+	misses.Lock()
+	n = len(misses.m)
+	misses.Unlock()
+	if n != 1 {
+		t.Errorf("%d addresses left after sweeping expired windows, want 1", n)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// inTempDir runs the rest of a test in an empty directory with a fresh file
+// store, since the file store and aliases work relative to the working
+// directory
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStore := store
+	store = fileStore{}
+	t.Cleanup(func() {
+		store = oldStore
+		os.Chdir(wd)
+	})
+}
+
+// saveTestLink saves a link to destination with the given code, failing the
+// test if it can't
+func saveTestLink(t *testing.T, code, destination string) *Link {
+	t.Helper()
+	l := &Link{Hash: code, Destination: destination}
+	err := store.CreateLink(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
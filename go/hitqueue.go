@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+)
+
+// A hitWriter records hits in the background so redirects don't wait on
+// storage. There's one writer goroutine, so hits are stored in the order
+// they came in.
+type hitWriter struct {
+	mu     sync.RWMutex // held for writing only to close queue
+	closed bool
+	queue  chan queuedHit
+	done   chan struct{}
+}
+
+type queuedHit struct {
+	link *Link
+	hit  Hit
+}
+
+var (
+	hitQueueFull = expvar.NewInt("hit_queue_full")
+
+	// hitQueue is started in main with -hit-buffer; when it's nil hits are
+	// written before the request finishes
+	hitQueue *hitWriter
+)
+
+func newHitWriter(size int) *hitWriter {
+	w := &hitWriter{queue: make(chan queuedHit, size), done: make(chan struct{})}
+	go w.run()
+
+	expvar.Publish("hit_queue_depth", expvar.Func(func() any {
+		return len(w.queue)
+	}))
+	return w
+}
+
+func (w *hitWriter) run() {
+	defer close(w.done)
+	for q := range w.queue {
+		err := writeHit(q.link, q.hit)
+		if err != nil {
+			log.Printf("recording hit on %s: %v", q.link.Hash, err)
+		}
+	}
+}
+
+// add queues a hit. When the queue is full the hit is written right away
+// instead, which slows that request down but never loses the hit. The same
+// goes for a request that outlasted the shutdown deadline.
+func (w *hitWriter) add(l *Link, h Hit) {
+	if w.tryAdd(queuedHit{l, h}) {
+		return
+	}
+	hitQueueFull.Add(1)
+	err := writeHit(l, h)
+	if err != nil {
+		log.Printf("recording hit on %s: %v", l.Hash, err)
+	}
+}
+
+func (w *hitWriter) tryAdd(q queuedHit) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return false
+	}
+	select {
+	case w.queue <- q:
+		return true
+	default:
+		return false
+	}
+}
+
+// close writes out every queued hit, or gives up when ctx is done. Hits
+// added after it's called are written straight away.
+func (w *hitWriter) close(ctx context.Context) error {
+	w.mu.Lock()
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A Hit is one recorded visit to a link. Hits are stored as JSON on "hit: "
+// lines after the link's destination.
+type Hit struct {
+	Time      time.Time `json:"time"`
+	UserAgent string    `json:"ua,omitempty"`
+	Referrer  string    `json:"referrer,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	City      string    `json:"city,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Source    string    `json:"source,omitempty"`
+
+	// Method tells image beacons (GET) apart from fetch/sendBeacon ones
+	//	(POST) on /collect/
+	Method string `json:"method,omitempty"`
+
+	// UTM holds the utm_ parameters the link was visited with, keyed
+	//	without the prefix: source, medium, campaign, term and content
+	UTM map[string]string `json:"utm,omitempty"`
+
+	// Page, Language and Screen come from /collect.js pageviews: the page
+	//	the script was on, without its query string, and the browser's
+	//	language and screen size
+	Page     string `json:"page,omitempty"`
+	Language string `json:"lang,omitempty"`
+	Screen   string `json:"screen,omitempty"`
+
+	// Variant is which of a link's Variants this click was sent to,
+	//	counting from 1
+	Variant int `json:"variant,omitempty"`
+
+	// GeoRule is which of a link's GeoRules sent this click somewhere,
+	//	counting from 1
+	GeoRule int `json:"geo_rule,omitempty"`
+
+	// Destination is where a link with mirrors sent this click
+	Destination string `json:"destination,omitempty"`
+
+	// Visitor identifies the browser across hits, through a cookie
+	Visitor string `json:"visitor,omitempty"`
+
+	// VisitorHash is a hash of the IP and user agent that changes daily,
+	//	for counting unique visitors without a cookie
+	VisitorHash string `json:"visitor_hash,omitempty"`
+
+	// Visit is "new" or "returning" with -classify-visitors, depending on
+	//	whether the visitor already had a cookie
+	Visit string `json:"visit,omitempty"`
+
+	// Conversion marks a /collect/?conversion=1 beacon rather than a
+	//	click; Attributed is set if it came within the attribution window
+	//	of a click by the same visitor
+	Conversion bool `json:"conversion,omitempty"`
+	Attributed bool `json:"attributed,omitempty"`
+
+	// Pixel marks a load of the /pixel/ tracking image, such as an email
+	//	being opened, rather than a click
+	Pixel bool `json:"pixel,omitempty"`
+
+	// OverLimit marks a click turned away because the link had used up
+	//	its MaxClicks; it isn't counted as a click
+	OverLimit bool `json:"over_limit,omitempty"`
+
+	// TLS details, recorded with -record-tls for requests we got over
+	//	TLS ourselves
+	TLSVersion string `json:"tls_version,omitempty"`
+	TLSCipher  string `json:"tls_cipher,omitempty"`
+	ALPN       string `json:"alpn,omitempty"`
+
+	// Error is set on clicks we couldn't send anywhere
+	Error string `json:"error,omitempty"`
+}
+
+var (
+	// sourceFromSuffix lets one link tell channels apart: a hit on
+	// /go/<hash>/email is recorded with the source "email"
+	sourceFromSuffix bool
+
+	// sourceParam does the same with a query parameter, as in
+	// /go/<hash>?src=email
+	sourceParam string
+)
+
+const maxSourceLength = 64
+
+// hitSource returns the traffic source a request was tagged with, if any
+func hitSource(r *http.Request) string {
+	if sourceFromSuffix {
+		m := validPathComponent(r.URL.Path)
+		if m != nil && m[3] != "" {
+			return m[3]
+		}
+	}
+	if sourceParam != "" {
+		source := r.URL.Query().Get(sourceParam)
+		if len(source) > maxSourceLength {
+			source = source[:maxSourceLength]
+		}
+		return source
+	}
+	return ""
+}
+
+// recordTLS adds the negotiated TLS version, cipher suite and protocol to
+// hits
+var recordTLS bool
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+func newHit(r *http.Request) Hit {
+	// the location comes from the whole address, before it's anonymized
+	ip := clientIP(r)
+	country, city := locate(ip)
+	h := Hit{
+		Time:      time.Now(),
+		UserAgent: r.Header.Get("User-Agent"),
+		Referrer:  r.Header.Get("Referer"),
+		Country:   country,
+		City:      city,
+		IP:        anonymize(ip),
+		Source:    hitSource(r),
+		Method:    r.Method,
+	}
+	h.VisitorHash = visitorHash(ip, h.UserAgent, h.Time)
+	h.UTM = utmFrom(r)
+	if recordTLS && r.TLS != nil {
+		h.TLSVersion = tlsVersionName(r.TLS.Version)
+		h.TLSCipher = tls.CipherSuiteName(r.TLS.CipherSuite)
+		h.ALPN = r.TLS.NegotiatedProtocol
+	}
+	return h
+}
+
+// utmParams are the campaign parameters recorded with hits
+var utmParams = []string{"source", "medium", "campaign", "term", "content"}
+
+const maxUTMLength = 128
+
+// utmFrom reads the utm_ parameters off r's URL, leaving out empty and
+// overlong ones
+func utmFrom(r *http.Request) map[string]string {
+	var utm map[string]string
+	q := r.URL.Query()
+	for _, p := range utmParams {
+		v := strings.TrimSpace(q.Get("utm_" + p))
+		if v == "" || len(v) > maxUTMLength {
+			continue
+		}
+		if utm == nil {
+			utm = map[string]string{}
+		}
+		utm[p] = v
+	}
+	return utm
+}
+
+// campaign is a hit's UTM campaign, source and medium together, or "" if
+// it had none of them
+func (h Hit) campaign() string {
+	if h.UTM["campaign"] == "" && h.UTM["source"] == "" && h.UTM["medium"] == "" {
+		return ""
+	}
+	part := func(p string) string {
+		if v := h.UTM[p]; v != "" {
+			return v
+		}
+		return "-"
+	}
+	return part("campaign") + " / " + part("source") + " / " + part("medium")
+}
+
+// hitFields are the parts of a hit that can be left out for privacy, either
+// for every link with -redact or per link
+var hitFields = []string{"ua", "referrer", "country", "ip", "visitor"}
+
+// redacted holds the fields that aren't recorded unless a link says so
+var redacted = map[string]bool{}
+
+// records reports whether hits on l include field, which is up to the link
+// if it has its own setting and up to -redact otherwise
+func (l *Link) records(field string) bool {
+	on, found := l.Fields[field]
+	if found {
+		return on
+	}
+	return !redacted[field]
+}
+
+func (l *Link) setField(field string, on bool) {
+	if l.Fields == nil {
+		l.Fields = map[string]bool{}
+	}
+	l.Fields[field] = on
+}
+
+type fieldSetting struct {
+	Name       string
+	Recorded   bool
+	Overridden bool // set on the link rather than inherited from -redact
+}
+
+// FieldSettings is shown on the analytics page
+func (l *Link) FieldSettings() []fieldSetting {
+	var settings []fieldSetting
+	for _, field := range hitFields {
+		_, overridden := l.Fields[field]
+		settings = append(settings, fieldSetting{field, l.records(field), overridden})
+	}
+	return settings
+}
+
+// recordHit saves h on l with only the fields l is allowed to record. With
+// -hit-buffer it's queued to be written in the background instead.
+func recordHit(l *Link, h Hit) error {
+	if !l.records("ua") {
+		h.UserAgent = ""
+	}
+	if !l.records("referrer") {
+		h.Referrer = ""
+	}
+	if !l.records("country") {
+		h.Country = ""
+		h.City = ""
+	}
+	if !l.records("ip") {
+		h.IP = ""
+	}
+	if !l.records("visitor") {
+		h.Visitor = ""
+		h.VisitorHash = ""
+	}
+
+	// click limits are only as exact as the counts they go by, so those
+	//	links don't wait in the queue
+	if hitQueue != nil && l.MaxClicks <= 0 {
+		hitQueue.add(l, h)
+		return nil
+	}
+	return writeHit(l, h)
+}
+
+// writeHit stores a hit and sets off whatever depends on it having been
+// counted
+func writeHit(l *Link, h Hit) error {
+	err := gotHit(l.Hash, h)
+	if err != nil {
+		return err
+	}
+	if !h.Conversion && !h.OverLimit && !h.fromBot() {
+		checkGoal(l)
+	}
+	notifyHit(l, h)
+	return nil
+}
+
+// botMarkers pick out crawlers, link unfurlers (Slack, Discord, Twitter and
+// friends all say "bot") and uptime monitors
+var botMarkers = []string{
+	"bot", "crawl", "spider", "slurp", "facebookexternalhit", "preview",
+	"whatsapp", "embedly", "pingdom", "statuscake", "monitor",
+	"headlesschrome", "google-inspectiontool", "vkshare",
+}
+
+// countBots counts clicks from bots like any others, as before they were
+// set apart
+var countBots bool
+
+func isBot(ua string) bool {
+	ua = strings.ToLower(ua)
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromBot reports whether a hit is set apart as bot traffic rather than
+// counted as a click
+func (h Hit) fromBot() bool {
+	return !countBots && isBot(h.UserAgent)
+}
+
+// referrerHost returns the lowercased host of a hit's referrer, or "" if it
+// didn't have one
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return ""
+	}
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// referrerSite is the site a referrer belongs to for the referrers
+// breakdown, which counts www.example.com and example.com together
+func referrerSite(referrer string) string {
+	return strings.TrimPrefix(referrerHost(referrer), "www.")
+}
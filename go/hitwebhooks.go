@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	// hitWebhook is POSTed to for every hit on every link, on top of the
+	// link's own Webhook
+	hitWebhook string
+
+	// webhookSecret signs hit webhook bodies so receivers can tell they're
+	// really from us. Without it they go unsigned.
+	webhookSecret string
+)
+
+// retries after the first attempt, waiting webhookBackoff, then twice that,
+// and so on
+const (
+	webhookRetries = 5
+	webhookBackoff = time.Second
+)
+
+// hitEvent is the body of a hit webhook
+type hitEvent struct {
+	Event       string    `json:"event"`
+	Hash        string    `json:"hash"`
+	Destination string    `json:"destination"`
+	Time        time.Time `json:"time"`
+	Hit         Hit       `json:"hit"`
+}
+
+// notifyHit sends h to the link's webhook and the global one, whichever are
+// set. It never blocks on the network.
+func notifyHit(l *Link, h Hit) {
+	if l.Webhook == "" && hitWebhook == "" {
+		return
+	}
+
+	event := "hit"
+	if h.Conversion {
+		event = "conversion"
+	}
+	payload, err := json.Marshal(hitEvent{
+		Event:       event,
+		Hash:        l.Hash,
+		Destination: l.Destination,
+		Time:        time.Now(),
+		Hit:         h,
+	})
+	if err != nil {
+		return
+	}
+
+	// the link's webhook was picked by whoever made the link, so unlike
+	//	the one we're configured with it only gets to public addresses
+	if l.Webhook != "" {
+		deliverWebhook(linkWebhookClient, l.Webhook, l.Hash, payload, 0)
+	}
+	if hitWebhook != "" {
+		deliverWebhook(hitWebhookClient, hitWebhook, l.Hash, payload, 0)
+	}
+}
+
+// webhookSignature is the X-Signature header for a body: "sha256=" and the
+// hex HMAC-SHA256 of it keyed with webhookSecret
+func webhookSignature(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook POSTs payload to url on the outbound pool. Failed attempts
+// are tried again with exponential backoff; the wait happens on a timer
+// rather than in a worker, so a slow receiver can't hold up the pool.
+func deliverWebhook(client *http.Client, url, hash string, payload []byte, attempt int) {
+	outbound.submit(func() {
+		err := postWebhook(client, url, payload, attempt)
+		if err == nil {
+			return
+		}
+		if attempt >= webhookRetries {
+			log.Printf("hit webhook for %s: giving up after %d attempts: %v", hash, attempt+1, err)
+			return
+		}
+		time.AfterFunc(webhookBackoff<<attempt, func() {
+			deliverWebhook(client, url, hash, payload, attempt+1)
+		})
+	})
+}
+
+var (
+	hitWebhookClient  = &http.Client{Timeout: 10 * time.Second}
+	linkWebhookClient = newPublicClient(10 * time.Second)
+)
+
+func postWebhook(client *http.Client, url string, payload []byte, attempt int) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "linkanalytics-webhook")
+	// receivers can use this to notice retries of the same hit
+	req.Header.Set("X-Attempt", fmt.Sprint(attempt+1))
+	if webhookSecret != "" {
+		req.Header.Set("X-Signature", webhookSignature(payload))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLinkWebhooksStayPublic(t *testing.T) {
+	for _, webhook := range []string{"http://127.0.0.1:9000/", "http://localhost/hook", "http://169.254.169.254/latest", "http://[::1]/", "http://10.0.0.5/"} {
+		req := &linkRequest{Destination: "https://example.com/", Webhook: webhook}
+		if err := req.validate(); err == nil {
+			t.Errorf("a link was allowed the webhook %s", webhook)
+		}
+	}
+
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+	}))
+	defer server.Close()
+
+	// a link saved before the check, or whose host has since moved, is
+	//	still stopped when it's dialed
+	err := postWebhook(linkWebhookClient, server.URL, []byte("{}"), 0)
+	if !errors.Is(err, errNotPublic) {
+		t.Errorf("link webhook to %s = %v, want errNotPublic", server.URL, err)
+	}
+	// the -hit-webhook the server was started with can go anywhere
+	err = postWebhook(hitWebhookClient, server.URL, []byte("{}"), 0)
+	if err != nil {
+		t.Errorf("hit webhook to %s = %v", server.URL, err)
+	}
+	if received != 1 {
+		t.Errorf("the server got %d webhooks, want 1", received)
+	}
+}
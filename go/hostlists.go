@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var (
+	// allowedHosts, when there are any, are the only hosts links can go
+	// to, along with their subdomains. deniedHosts and their subdomains
+	// are never allowed. Both come from comma separated flags.
+	allowedHosts, deniedHosts []string
+
+	// denyShortLinks rejects destinations that are links on a URL
+	// shortener, ourselves included, since they hide where a link really
+	// goes
+	denyShortLinks bool
+)
+
+// shortenerHosts are well known URL shorteners, for -deny-short-links
+var shortenerHosts = []string{
+	"bit.ly", "bitly.com", "buff.ly", "cutt.ly", "goo.gl", "is.gd", "lnkd.in",
+	"ow.ly", "rb.gy", "rebrand.ly", "s.id", "shorturl.at", "t.co", "t.ly",
+	"tiny.cc", "tinyurl.com", "v.gd",
+}
+
+// parseHosts reads a comma separated list of host names
+func parseHosts(s string) []string {
+	var hosts []string
+	for _, host := range strings.Split(s, ",") {
+		host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// hostIn reports whether host is one of hosts or a subdomain of one
+func hostIn(host string, hosts []string) bool {
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDestinationHost applies -allow-hosts, -deny-hosts and
+// -deny-short-links to a destination that's otherwise valid
+func checkDestinationHost(u *url.URL) error {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if len(allowedHosts) > 0 && !hostIn(host, allowedHosts) {
+		return errors.New("this server doesn't link to " + host + "; it only links to " + strings.Join(allowedHosts, ", "))
+	}
+	if hostIn(host, deniedHosts) {
+		return errors.New("this server doesn't link to " + host)
+	}
+	if denyShortLinks {
+		short := hostIn(host, shortenerHosts)
+		if base, err := url.Parse(baseURL); err == nil && baseURL != "" && strings.EqualFold(base.Hostname(), host) {
+			short = true
+		}
+		if short {
+			return errors.New(host + " is a link shortener; link to where it goes instead")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// An IDGenerator decides what a new link's code looks like
+type IDGenerator interface {
+	Next(destination string) (string, error)
+}
+
+// hashIDs gives every destination the same code each time, so saving a
+// destination twice updates one link
+type hashIDs struct{}
+
+func (hashIDs) Next(destination string) (string, error) {
+	h := sha256.Sum256([]byte(destination))
+	return hex.EncodeToString(h[:]), nil
+}
+
+// randomIDs gives every new link a short random base62 code that isn't in
+// use yet
+type randomIDs struct {
+	length int
+}
+
+// maxCodeAttempts is how many taken codes randomIDs will draw before
+// giving up, which only happens if nearly every code is in use
+const maxCodeAttempts = 100
+
+var errNoFreeCode = errors.New("couldn't find a code that isn't in use")
+
+// drawCode picks a candidate code for randomIDs to check. It's only ever
+// replaced in tests, to make collisions happen on purpose.
+var drawCode = randomCode
+
+func (g randomIDs) Next(destination string) (string, error) {
+	for i := 0; i < maxCodeAttempts; i++ {
+		code, err := drawCode(g.length)
+		if err != nil {
+			return "", err
+		}
+		inUse, err := codeInUse(code)
+		if err != nil {
+			return "", err
+		}
+		if !inUse {
+			return code, nil
+		}
+	}
+	return "", errNoFreeCode
+}
+
+// codeLength is how long generated codes are. Seven base62 characters
+// give 3.5 trillion codes, so collisions (which are retried) stay rare.
+const codeLength = 7
+
+// idGenerator is picked with -ids. Links made by hashIDs before random
+// codes became the default keep their hashes and still resolve.
+var idGenerator IDGenerator = randomIDs{codeLength}
+
+func idGeneratorNamed(name string) (IDGenerator, error) {
+	switch name {
+	case "hash":
+		return hashIDs{}, nil
+	case "random":
+		return randomIDs{codeLength}, nil
+	}
+	return nil, fmt.Errorf("unknown ID generator %q (want hash or random)", name)
+}
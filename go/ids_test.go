@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubDraws makes randomIDs draw codes from a list, repeating the last one
+// once it runs out, and returns how many have been drawn so far
+func stubDraws(t *testing.T, codes ...string) *int {
+	t.Helper()
+	n := 0
+	old := drawCode
+	drawCode = func(int) (string, error) {
+		code := codes[len(codes)-1]
+		if n < len(codes) {
+			code = codes[n]
+		}
+		n++
+		return code, nil
+	}
+	t.Cleanup(func() { drawCode = old })
+	return &n
+}
+
+// brokenStore fails every lookup, like a database that's gone away
+type brokenStore struct{ Store }
+
+var errBroken = errors.New("store is down")
+
+func (brokenStore) LoadLink(hash string) (*Link, error) {
+	return nil, errBroken
+}
+
+func TestRandomIDsRetriesCollisions(t *testing.T) {
+	tests := []struct {
+		name    string
+		links   []string
+		aliases []string
+		draws   []string
+		broken  bool
+		want    string
+		wantErr error
+		tries   int
+	}{
+		{name: "free", draws: []string{"aaaaaaa"}, want: "aaaaaaa", tries: 1},
+		{name: "taken by a link", links: []string{"aaaaaaa"}, draws: []string{"aaaaaaa", "bbbbbbb"}, want: "bbbbbbb", tries: 2},
+		{name: "taken by an alias", aliases: []string{"aaaaaaa"}, draws: []string{"aaaaaaa", "bbbbbbb"}, want: "bbbbbbb", tries: 2},
+		{name: "taken twice", links: []string{"aaaaaaa", "bbbbbbb"}, draws: []string{"aaaaaaa", "bbbbbbb", "ccccccc"}, want: "ccccccc", tries: 3},
+		{name: "all taken", links: []string{"aaaaaaa"}, draws: []string{"aaaaaaa"}, wantErr: errNoFreeCode, tries: maxCodeAttempts},
+		{name: "store down", broken: true, draws: []string{"aaaaaaa", "bbbbbbb"}, wantErr: errBroken, tries: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t)
+			for _, code := range tt.links {
+				saveTestLink(t, code, "https://example.com/"+code)
+			}
+			for _, code := range tt.aliases {
+				err := saveAlias(code, alias{To: "zzzzzzz", Until: time.Now().Add(time.Hour)})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.broken {
+				store = brokenStore{store}
+			}
+			tries := stubDraws(t, tt.draws...)
+
+			got, err := randomIDs{codeLength}.Next("https://example.com/")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Next() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Next() = %q, want %q", got, tt.want)
+			}
+			if *tries != tt.tries {
+				t.Errorf("drew %d codes, want %d", *tries, tt.tries)
+			}
+		})
+	}
+}
+
+// fixedIDs hands out the same code every time without checking it, like a
+// generator that lost a race with another instance
+type fixedIDs string
+
+func (f fixedIDs) Next(string) (string, error) {
+	return string(f), nil
+}
+
+func TestCreateLinkConflicts(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  IDGenerator
+		body string
+		want int
+	}{
+		{name: "generated code is free", ids: randomIDs{codeLength}, body: `{"destination": "https://example.com/new"}`, want: http.StatusCreated},
+		{name: "generated code was taken", ids: fixedIDs("taken12"), body: `{"destination": "https://example.com/new"}`, want: http.StatusConflict},
+		{name: "slug is taken", ids: randomIDs{codeLength}, body: `{"destination": "https://example.com/new", "slug": "taken12"}`, want: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t)
+			saveTestLink(t, "taken12", "https://example.com/old")
+			old := idGenerator
+			idGenerator = tt.ids
+			t.Cleanup(func() { idGenerator = old })
+			stubDraws(t, "taken12", "free123")
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/links", strings.NewReader(tt.body))
+			apiLinksHandler(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			// whatever happened, the link that had the code is untouched
+			l, err := store.LoadLink("taken12")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l.Destination != "https://example.com/old" {
+				t.Errorf("taken12 now goes to %s", l.Destination)
+			}
+			if tt.want == http.StatusCreated {
+				if _, err := store.LoadLink("free123"); err != nil {
+					t.Errorf("the retried code wasn't saved: %v", err)
+				}
+			}
+		})
+	}
+}
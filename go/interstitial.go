@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// interstitialAll shows every link's destination before redirecting, as
+// if they all had Interstitial set
+var interstitialAll bool
+
+// showsInterstitial reports whether a click on l should see where it's
+// going before it's sent there. Only plain GETs do: ?confirm=1 is the
+// click through from the page itself, and a POST is a passphrase that was
+// typed in on a page of ours already.
+func (l *Link) showsInterstitial(r *http.Request) bool {
+	return (l.Interstitial || interstitialAll) && r.Method == http.MethodGet && r.URL.Query().Get("confirm") != "1"
+}
+
+// serveInterstitial shows l's destination and a link through to it, which
+// is the same click with ?confirm=1 added. Nothing is recorded until it's
+// followed.
+func serveInterstitial(w http.ResponseWriter, r *http.Request, l *Link) {
+	q := r.URL.Query()
+	destination := l.Destination
+	if l.PassQuery {
+		destination = withQuery(destination, q)
+	}
+	q.Set("confirm", "1")
+	page := struct {
+		Destination string
+		Host        string
+		Continue    string
+
+		// Varies is set when mirrors, variants or rules might send the click
+		//	somewhere other than Destination
+		Varies bool
+	}{Destination: destination, Host: destination, Continue: "?" + q.Encode(), Varies: len(l.Mirrors) > 0 || len(l.Variants) > 0 || len(l.Rules) > 0 || len(l.GeoRules) > 0 || len(l.Schedule) > 0 || l.Ruleset != ""}
+	if u, err := url.Parse(destination); err == nil {
+		page.Host = u.Host
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	templates.ExecuteTemplate(w, "interstitial.html", page)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// trustedProxies are the networks whose X-Forwarded-For headers we believe,
+// set with -trusted-proxies. Requests from anywhere else are taken to come
+// from their RemoteAddr, whatever they claim.
+var trustedProxies []*net.IPNet
+
+func parseTrustedProxies(list string) error {
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(p)
+		if err != nil {
+			return fmt.Errorf("bad trusted proxy %q: %w", p, err)
+		}
+		trustedProxies = append(trustedProxies, network)
+	}
+	return nil
+}
+
+func trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor follows X-Forwarded-For back from a trusted proxy to the
+// first address that isn't one of ours. Each proxy appends the address it
+// got the request from, so only the right end of the header can be
+// believed.
+func forwardedFor(r *http.Request, remote string) string {
+	if !trustedProxy(remote) {
+		return remote
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		remote = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+	return remote
+}
+
+// anonymizeIP is how addresses are stored in hits, set with -anonymize-ip:
+// "" keeps them whole, "truncate" zeroes the host part (the last octet of
+// IPv4, everything past the /48 of IPv6) and "hash" keeps only a salted
+// hash, so repeat visits can be told apart without storing the address
+var anonymizeIP string
+
+// ipSalt is the key for hashed addresses. Without one a random salt is made
+// at startup, so hashes only match within one run.
+var ipSalt string
+
+var (
+	ipSaltOnce sync.Once
+	ipSaltData []byte
+)
+
+func ipSaltKey() []byte {
+	ipSaltOnce.Do(func() {
+		if ipSalt != "" {
+			ipSaltData = []byte(ipSalt)
+			return
+		}
+		ipSaltData = make([]byte, 32)
+		rand.Read(ipSaltData)
+	})
+	return ipSaltData
+}
+
+func validAnonymizeIP(mode string) error {
+	switch mode {
+	case "", "truncate", "hash":
+		return nil
+	}
+	return fmt.Errorf("unknown -anonymize-ip mode %q (want truncate or hash)", mode)
+}
+
+// anonymize returns ip the way -anonymize-ip says to store it
+func anonymize(ip string) string {
+	switch anonymizeIP {
+	case "truncate":
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return ""
+		}
+		if v4 := parsed.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return parsed.Mask(net.CIDRMask(48, 128)).String()
+	case "hash":
+		mac := hmac.New(sha256.New, ipSaltKey())
+		mac.Write([]byte(ip))
+		return hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+	return ip
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"container/list"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// cachedStore keeps recently loaded links in memory so busy links don't
+// have their file or row read on every click. Anything that changes a link
+// goes through the store, which drops it from the cache; entries also
+// expire after linkCacheTTL, for links changed by another instance sharing
+// the same database.
+type cachedStore struct {
+	Store
+
+	mu  sync.Mutex
+	m   map[string]*list.Element
+	lru *list.List // of *cachedLink, most recently used first
+
+	// version goes up on every invalidation, so a link loaded while it
+	//	was being changed isn't cached over the change
+	version uint64
+}
+
+type cachedLink struct {
+	link    *Link
+	expires time.Time
+}
+
+var (
+	linkCacheSize = 1000
+	linkCacheTTL  = time.Minute
+
+	linkCacheHits   = expvar.NewInt("link_cache_hits")
+	linkCacheMisses = expvar.NewInt("link_cache_misses")
+)
+
+func newCachedStore(s Store) *cachedStore {
+	return &cachedStore{Store: s, m: map[string]*list.Element{}, lru: list.New()}
+}
+
+// LoadLink returns a deep copy of the cached link, so callers can change it,
+// slices and all, without changing it for everyone else
+func (s *cachedStore) LoadLink(hash string) (*Link, error) {
+	s.mu.Lock()
+	if e, found := s.m[hash]; found {
+		c := e.Value.(*cachedLink)
+		if time.Now().Before(c.expires) {
+			s.lru.MoveToFront(e)
+			l := c.link.clone()
+			s.mu.Unlock()
+			linkCacheHits.Add(1)
+			return l, nil
+		}
+		s.lru.Remove(e)
+		delete(s.m, hash)
+	}
+	version := s.version
+	s.mu.Unlock()
+	linkCacheMisses.Add(1)
+
+	l, err := s.Store.LoadLink(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.version != version {
+		return l, nil
+	}
+	if e, found := s.m[hash]; found {
+		s.lru.Remove(e)
+	}
+	s.m[hash] = s.lru.PushFront(&cachedLink{l.clone(), time.Now().Add(linkCacheTTL)})
+	for s.lru.Len() > linkCacheSize {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.m, oldest.Value.(*cachedLink).link.Hash)
+	}
+	return l, nil
+}
+
+// clone copies l along with its slices and maps, which a plain copy of the
+// struct would share
+func (l *Link) clone() *Link {
+	c := *l
+	c.Tags = append([]string(nil), l.Tags...)
+	c.Mirrors = append([]string(nil), l.Mirrors...)
+	c.Variants = append([]Variant(nil), l.Variants...)
+	c.Schedule = append([]ScheduledDestination(nil), l.Schedule...)
+	c.Rules = nil
+	for _, rule := range l.Rules {
+		rule.Countries = append([]string(nil), rule.Countries...)
+		c.Rules = append(c.Rules, rule)
+	}
+	c.GeoRules = nil
+	for _, rule := range l.GeoRules {
+		rule.Countries = append([]string(nil), rule.Countries...)
+		c.GeoRules = append(c.GeoRules, rule)
+	}
+	if l.Fields != nil {
+		c.Fields = map[string]bool{}
+		for field, on := range l.Fields {
+			c.Fields[field] = on
+		}
+	}
+	return &c
+}
+
+func (s *cachedStore) invalidate(hashes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	for _, hash := range hashes {
+		if e, found := s.m[hash]; found {
+			s.lru.Remove(e)
+			delete(s.m, hash)
+		}
+	}
+}
+
+// AppendHit keeps the cached LastActive up to date rather than dropping the
+// link, since every click would otherwise empty the cache of busy links
+func (s *cachedStore) AppendHit(hash string, h Hit) error {
+	err := s.Store.AppendHit(hash, h)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if e, found := s.m[hash]; found {
+		// replaced rather than changed in place, so copies already
+		//	handed out aren't changed under their callers
+		l := *e.Value.(*cachedLink).link
+		l.LastActive = time.Now()
+		e.Value.(*cachedLink).link = &l
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *cachedStore) SaveLink(l *Link) error {
+	defer s.invalidate(l.Hash)
+	return s.Store.SaveLink(l)
+}
+
+func (s *cachedStore) CreateLink(l *Link) error {
+	defer s.invalidate(l.Hash)
+	return s.Store.CreateLink(l)
+}
+
+func (s *cachedStore) DeleteLink(hash string) error {
+	defer s.invalidate(hash)
+	return s.Store.DeleteLink(hash)
+}
+
+func (s *cachedStore) RenameLink(hash, code string) error {
+	defer s.invalidate(hash, code)
+	return s.Store.RenameLink(hash, code)
+}
+
+func (s *cachedStore) SetDestination(hash, destination string) error {
+	defer s.invalidate(hash)
+	return s.Store.SetDestination(hash, destination)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCachedLinksAreCopies(t *testing.T) {
+	inTempDir(t)
+	store = newCachedStore(store)
+	l := &Link{
+		Hash:        "abc1234",
+		Destination: "https://example.com/",
+		Tags:        []string{"a", "b", "c"},
+		Mirrors:     []string{"https://mirror.example.com/"},
+		Rules:       []Rule{{Device: "ios", Destination: "https://apps.apple.com/"}},
+		GeoRules:    []GeoRule{{Countries: []string{"DE"}, Destination: "https://example.de/"}},
+		Fields:      map[string]bool{"ip": false},
+		Expires:     time.Now().Add(time.Hour),
+	}
+	err := store.CreateLink(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := store.LoadLink("abc1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = want.clone()
+
+	// an update that fails after changing its copy of the link
+	ttl := Duration(time.Hour)
+	err = updateLink("abc1234", &linkUpdate{RemoveTags: []string{"a"}, AddTags: []string{"d"}, IdleTTL: &ttl})
+	if err != errIdleAndFixedExpiry {
+		t.Fatalf("updateLink() = %v, want errIdleAndFixedExpiry", err)
+	}
+
+	// and a caller scribbling on everything it was handed
+	got, err := store.LoadLink("abc1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Tags[0] = "changed"
+	got.Mirrors[0] = "changed"
+	got.Rules[0].Destination = "changed"
+	got.GeoRules[0].Countries[0] = "FR"
+	got.Fields["ip"] = true
+
+	got, err = store.LoadLink("abc1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cached link changed:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestApplyTagsDoesntShare(t *testing.T) {
+	tags := make([]string, 3, 10)
+	copy(tags, []string{"a", "b", "c"})
+	l := &Link{Tags: tags}
+	(&linkUpdate{RemoveTags: []string{"a"}, AddTags: []string{"d"}}).apply(l)
+	if !reflect.DeepEqual(l.Tags, []string{"b", "c", "d"}) {
+		t.Errorf("tags = %q", l.Tags)
+	}
+	if !reflect.DeepEqual(tags[:4], []string{"a", "b", "c", ""}) {
+		t.Errorf("the original tags were changed to %q", tags[:4])
+	}
+}
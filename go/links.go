@@ -0,0 +1,787 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A linkRequest is everything that can be set when creating a link, from
+// the create form or the JSON API
+type linkRequest struct {
+	Destination  string                 `json:"destination"`
+	Slug         string                 `json:"slug"`
+	Workspace    string                 `json:"workspace"`
+	Value        float64                `json:"value"`
+	Goal         int                    `json:"goal"`
+	Webhook      string                 `json:"webhook"`
+	MaxClicks    int                    `json:"max_clicks"`
+	Password     string                 `json:"password"`
+	IdleTTL      Duration               `json:"idle_ttl"`
+	Expires      time.Time              `json:"expires"`
+	ExpiresIn    Duration               `json:"expires_in"`
+	Fields       map[string]bool        `json:"fields"`
+	Mirrors      []string               `json:"mirrors"`
+	Variants     []Variant              `json:"variants"`
+	PassQuery    bool                   `json:"pass_query"`
+	Interstitial bool                   `json:"interstitial"`
+	Redirect     int                    `json:"redirect_status"`
+	Ruleset      string                 `json:"ruleset"`
+	Rules        []Rule                 `json:"rules"`
+	GeoRules     []GeoRule              `json:"geo_rules"`
+	Schedule     []ScheduledDestination `json:"schedule"`
+	Timezone     string                 `json:"timezone"`
+	Tags         []string               `json:"tags"`
+	Title        string                 `json:"title"`
+	Notes        string                 `json:"notes"`
+
+	// Duplicate makes a new link even if one already goes to the same
+	//	destination
+	Duplicate bool `json:"duplicate"`
+}
+
+// linkRequestFromForm reads the create form's fields
+func linkRequestFromForm(r *http.Request) (*linkRequest, error) {
+	req := &linkRequest{
+		Destination:  r.FormValue("destination"),
+		Slug:         r.FormValue("slug"),
+		Password:     r.FormValue("password"),
+		Workspace:    r.FormValue("workspace"),
+		Ruleset:      r.FormValue("ruleset"),
+		Timezone:     r.FormValue("timezone"),
+		Webhook:      strings.TrimSpace(r.FormValue("webhook")),
+		PassQuery:    r.FormValue("pass_query") != "",
+		Interstitial: r.FormValue("interstitial") != "",
+		Duplicate:    r.FormValue("duplicate") != "",
+		Tags:         parseTags(r.FormValue("tags")),
+		Title:        r.FormValue("title"),
+		Notes:        r.FormValue("notes"),
+	}
+
+	if v := r.FormValue("value"); v != "" {
+		value, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, errors.New("value must be a non-negative number")
+		}
+		req.Value = value
+	}
+
+	if v := r.FormValue("redirect_status"); v != "" {
+		status, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.New("redirect_status must be 301, 302, 307 or 308")
+		}
+		req.Redirect = status
+	}
+
+	if v := r.FormValue("goal"); v != "" {
+		goal, err := strconv.Atoi(v)
+		if err != nil || goal <= 0 {
+			return nil, errors.New("goal must be a positive whole number of clicks")
+		}
+		req.Goal = goal
+	}
+
+	if v := r.FormValue("max_clicks"); v != "" {
+		maxClicks, err := strconv.Atoi(v)
+		if err != nil || maxClicks <= 0 {
+			return nil, errors.New("max_clicks must be a positive whole number")
+		}
+		req.MaxClicks = maxClicks
+	}
+
+	if v := r.FormValue("idle_ttl"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			return nil, errors.New("idle_ttl must be a positive duration like 720h")
+		}
+		req.IdleTTL = Duration(ttl)
+	}
+
+	// the form's date picker gives a local time without a zone
+	if v := r.FormValue("expires"); v != "" {
+		expires, err := time.ParseInLocation("2006-01-02T15:04", v, time.Local)
+		if err != nil {
+			return nil, errors.New("expires must be a date and time like 2024-12-31T23:59")
+		}
+		req.Expires = expires
+	}
+
+	if v := r.FormValue("expires_in"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, errors.New("expires_in must be a positive duration like 720h")
+		}
+		req.ExpiresIn = Duration(d)
+	}
+
+	for _, field := range hitFields {
+		switch r.FormValue("track_" + field) {
+		case "on":
+			req.setField(field, true)
+		case "off":
+			req.setField(field, false)
+		}
+	}
+
+	for _, line := range strings.Split(r.FormValue("mirrors"), "\n") {
+		if strings.TrimSpace(line) != "" {
+			req.Mirrors = append(req.Mirrors, line)
+		}
+	}
+
+	// the form only has app store style rules; everyone else goes to the
+	//	destination
+	for _, device := range []string{"ios", "android"} {
+		if d := strings.TrimSpace(r.FormValue(device + "_destination")); d != "" {
+			req.Rules = append(req.Rules, Rule{Device: device, Destination: d})
+		}
+	}
+
+	schedule, err := parseSchedule(r.FormValue("schedule"))
+	if err != nil {
+		return nil, err
+	}
+	req.Schedule = schedule
+
+	geoRules, err := parseGeoRules(r.FormValue("geo_rules"))
+	if err != nil {
+		return nil, err
+	}
+	req.GeoRules = geoRules
+
+	variants, err := parseVariants(r.FormValue("variants"))
+	if err != nil {
+		return nil, err
+	}
+	req.Variants = variants
+	return req, nil
+}
+
+func (req *linkRequest) setField(field string, on bool) {
+	if req.Fields == nil {
+		req.Fields = map[string]bool{}
+	}
+	req.Fields[field] = on
+}
+
+// validate checks a request and normalizes its destinations in place
+func (req *linkRequest) validate() error {
+	destination, _, err := normalizeDestination(req.Destination)
+	if err != nil {
+		return err
+	}
+	req.Destination = destination
+
+	if req.Slug != "" && !validSlug.MatchString(req.Slug) {
+		return errors.New("slugs must be 3 to 64 letters and digits")
+	}
+	if req.Workspace != "" && !validWorkspace.MatchString(req.Workspace) {
+		return errors.New("workspace names may only contain lowercase letters, digits, - and _")
+	}
+	if !(req.Value >= 0) || math.IsInf(req.Value, 0) {
+		return errors.New("value must be a non-negative number")
+	}
+	if !validRedirectStatus(req.Redirect) {
+		return errors.New("redirect_status must be 301, 302, 307 or 308")
+	}
+	if req.Goal < 0 {
+		return errors.New("goal must be a positive whole number of clicks")
+	}
+	if req.Webhook != "" {
+		u, err := url.Parse(req.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("webhook must be an http:// or https:// URL")
+		}
+		// anyone who can make a link picks it, so it mustn't reach
+		//	us or the network we're on
+		if checkPublicHost(context.Background(), u.Hostname()) != nil {
+			return errors.New("webhook must be on the public internet, not a local or private address")
+		}
+	}
+	// bcrypt ignores anything past 72 bytes
+	if len(req.Password) > 72 {
+		return errors.New("passphrases can be at most 72 bytes")
+	}
+	if req.MaxClicks < 0 {
+		return errors.New("max_clicks must be a positive whole number")
+	}
+	if req.IdleTTL < 0 {
+		return errors.New("idle_ttl must be a positive duration like 720h")
+	}
+	if !req.Expires.IsZero() && req.ExpiresIn != 0 {
+		return errors.New("give expires or expires_in, not both")
+	}
+	if req.IdleTTL > 0 && (!req.Expires.IsZero() || req.ExpiresIn != 0) {
+		return errIdleAndFixedExpiry
+	}
+	if !req.Expires.IsZero() && !req.Expires.After(time.Now()) {
+		return errors.New("expires must be in the future")
+	}
+	if req.ExpiresIn < 0 {
+		return errors.New("expires_in must be a positive duration like 720h")
+	}
+	for field := range req.Fields {
+		if !isHitField(field) {
+			return errors.New("unknown hit field " + field)
+		}
+	}
+	for i, mirror := range req.Mirrors {
+		normalized, _, err := normalizeDestination(mirror)
+		if err != nil {
+			return errors.New("mirror " + strings.TrimSpace(mirror) + ": " + err.Error())
+		}
+		req.Mirrors[i] = normalized
+	}
+	err = validateVariants(req.Variants)
+	if err != nil {
+		return err
+	}
+	if len(req.Variants) > 0 && len(req.Mirrors) > 0 {
+		return errors.New("a link can take turns with mirrors or split clicks between variants, not both")
+	}
+	if req.Ruleset != "" && rulesets[req.Ruleset] == nil {
+		return errors.New("unknown ruleset " + req.Ruleset)
+	}
+	err = validateRules(req.Rules)
+	if err != nil {
+		return err
+	}
+	err = validateGeoRules(req.GeoRules)
+	if err != nil {
+		return err
+	}
+	err = validateSchedule(req.Schedule, req.Timezone)
+	if err != nil {
+		return err
+	}
+	if len(req.Schedule) > 0 && (len(req.Variants) > 0 || len(req.Mirrors) > 0) {
+		return errors.New("a link with a schedule can't also split clicks between variants or take turns with mirrors")
+	}
+	req.Title, err = cleanTitle(req.Title)
+	if err != nil {
+		return err
+	}
+	req.Notes, err = cleanNotes(req.Notes)
+	if err != nil {
+		return err
+	}
+	return validateTags(req.Tags)
+}
+
+// validSlug is what a custom slug can look like. Slugs share a namespace
+// with generated codes, so they're held to the same characters.
+var validSlug = regexp.MustCompile("^[a-zA-Z0-9]{3,64}$")
+
+// link makes the Link a validated request describes. It returns
+// errCodeTaken if the request's slug is an alias for a rotated link.
+func (req *linkRequest) link() (*Link, error) {
+	var l *Link
+	if req.Slug != "" {
+		_, err := findAlias(req.Slug)
+		if err == nil {
+			return nil, errCodeTaken
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		l = &Link{Destination: req.Destination, Hash: req.Slug, Created: time.Now()}
+	} else {
+		var err error
+		l, err = newLink(req.Destination)
+		if err != nil {
+			return nil, err
+		}
+	}
+	l.Workspace = req.Workspace
+	l.Value = req.Value
+	l.Goal = req.Goal
+	l.Webhook = req.Webhook
+	l.MaxClicks = req.MaxClicks
+	if req.Password != "" {
+		var err error
+		l.PasswordHash, err = hashPassphrase(req.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	l.IdleTTL = req.IdleTTL
+	l.Expires = req.Expires
+	if req.ExpiresIn > 0 {
+		l.Expires = l.Created.Add(time.Duration(req.ExpiresIn))
+	}
+	l.Fields = req.Fields
+	l.Mirrors = req.Mirrors
+	l.Variants = req.Variants
+	l.PassQuery = req.PassQuery
+	l.Interstitial = req.Interstitial
+	l.RedirectStatus = req.Redirect
+	l.Ruleset = req.Ruleset
+	l.Rules = req.Rules
+	l.GeoRules = req.GeoRules
+	l.Schedule = req.Schedule
+	l.Timezone = req.Timezone
+	l.Tags = req.Tags
+	l.Title = req.Title
+	l.Notes = req.Notes
+	return l, nil
+}
+
+func isHitField(field string) bool {
+	for _, f := range hitFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// linkJSON is how the API shows a link
+type linkJSON struct {
+	Hash         string                 `json:"hash"`
+	Destination  string                 `json:"destination"`
+	ShortURL     string                 `json:"short_url"`
+	Created      *time.Time             `json:"created,omitempty"`
+	Owner        string                 `json:"owner,omitempty"`
+	Workspace    string                 `json:"workspace,omitempty"`
+	Value        float64                `json:"value,omitempty"`
+	Goal         int                    `json:"goal,omitempty"`
+	Webhook      string                 `json:"webhook,omitempty"`
+	MaxClicks    int                    `json:"max_clicks,omitempty"`
+	IdleTTL      Duration               `json:"idle_ttl,omitempty"`
+	Expires      *time.Time             `json:"expires,omitempty"`
+	Expired      bool                   `json:"expired,omitempty"`
+	Fields       map[string]bool        `json:"fields,omitempty"`
+	Mirrors      []string               `json:"mirrors,omitempty"`
+	Variants     []Variant              `json:"variants,omitempty"`
+	PassQuery    bool                   `json:"pass_query,omitempty"`
+	Interstitial bool                   `json:"interstitial,omitempty"`
+	Redirect     int                    `json:"redirect_status"`
+	Ruleset      string                 `json:"ruleset,omitempty"`
+	Rules        []Rule                 `json:"rules,omitempty"`
+	GeoRules     []GeoRule              `json:"geo_rules,omitempty"`
+	Schedule     []ScheduledDestination `json:"schedule,omitempty"`
+	Timezone     string                 `json:"timezone,omitempty"`
+	Tags         []string               `json:"tags,omitempty"`
+	Title        string                 `json:"title,omitempty"`
+	Notes        string                 `json:"notes,omitempty"`
+	Disabled     bool                   `json:"disabled,omitempty"`
+	Archived     bool                   `json:"archived,omitempty"`
+	Protected    bool                   `json:"protected,omitempty"`
+	Clicks       int                    `json:"clicks"`
+	Uniques      int                    `json:"uniques"`
+	OverLimit    int                    `json:"over_limit,omitempty"`
+	Bots         int                    `json:"bots,omitempty"`
+	LastHit      *time.Time             `json:"last_hit,omitempty"`
+
+	// Existing is set when a create request found this link already
+	//	going to the same place, rather than making a new one
+	Existing bool `json:"existing,omitempty"`
+}
+
+func newLinkJSON(r *http.Request, l *Link, s *Summary) linkJSON {
+	j := linkJSON{
+		Hash:         l.Hash,
+		Destination:  l.Destination,
+		ShortURL:     shareableURL(r, l.Hash),
+		Owner:        l.Owner,
+		Workspace:    l.Workspace,
+		Value:        l.Value,
+		Goal:         l.Goal,
+		Webhook:      l.Webhook,
+		MaxClicks:    l.MaxClicks,
+		IdleTTL:      l.IdleTTL,
+		Fields:       l.Fields,
+		Mirrors:      l.Mirrors,
+		Variants:     l.Variants,
+		PassQuery:    l.PassQuery,
+		Interstitial: l.Interstitial,
+		Redirect:     l.redirectStatus(),
+		Ruleset:      l.Ruleset,
+		Rules:        l.Rules,
+		GeoRules:     l.GeoRules,
+		Schedule:     l.Schedule,
+		Timezone:     l.Timezone,
+		Tags:         l.Tags,
+		Title:        l.Title,
+		Notes:        l.Notes,
+		Disabled:     l.Disabled,
+		Archived:     l.Archived,
+		Protected:    l.PasswordHash != "",
+		Clicks:       s.Total,
+		Uniques:      s.Uniques,
+		OverLimit:    s.OverLimit,
+		Bots:         s.Bots,
+	}
+	if !l.Created.IsZero() {
+		j.Created = &l.Created
+	}
+	if !l.Expires.IsZero() {
+		j.Expires = &l.Expires
+		j.Expired = l.Expired()
+	}
+	if !s.LastHit.IsZero() {
+		j.LastHit = &s.LastHit
+	}
+	return j
+}
+
+// apiLinksHandler serves /api/v1/links (GET to list, POST to create) and
+// /api/v1/links/<hash> (GET for details, PATCH to repoint, DELETE to
+// remove) and what's under it, like /api/v1/links/<hash>/timeseries
+func apiLinksHandler(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/links"), "/")
+	if hash == "" {
+		switch r.Method {
+		case http.MethodGet:
+			listLinks(w, r)
+		case http.MethodPost:
+			createLink(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			apiError(w, http.StatusMethodNotAllowed, "use GET to list links or POST to create one")
+		}
+		return
+	}
+
+	hash, sub, _ := strings.Cut(hash, "/")
+	if !validHash.MatchString(hash) {
+		apiError(w, http.StatusNotFound, "no such link")
+		return
+	}
+	if l, err := store.LoadLink(hash); err == nil && !canSee(r, l) {
+		apiError(w, http.StatusNotFound, "no such link")
+		return
+	}
+	if sub != "" {
+		linkSubresource(w, r, hash, sub)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		showLink(w, r, hash)
+	case http.MethodPatch:
+		// the API is open without any auth, but changing links isn't
+		if !authConfigured() {
+			apiError(w, http.StatusForbidden, errNoAuth)
+			return
+		}
+		patchLink(w, r, hash)
+	case http.MethodDelete:
+		if !authConfigured() {
+			apiError(w, http.StatusForbidden, errNoAuth)
+			return
+		}
+		deleteLink(w, r, hash)
+	default:
+		w.Header().Set("Allow", "GET, PATCH, DELETE")
+		apiError(w, http.StatusMethodNotAllowed, "use GET to show a link, PATCH to change it or DELETE to remove it")
+	}
+}
+
+// linkSubresource serves the read-only views under /api/v1/links/<hash>/
+func linkSubresource(w http.ResponseWriter, r *http.Request, hash, sub string) {
+	var handler func(http.ResponseWriter, *http.Request, string)
+	switch sub {
+	case "timeseries":
+		handler = timeseriesHandler
+	case "hits.csv":
+		handler = hitsCSVHandler
+	default:
+		apiError(w, http.StatusNotFound, "no such resource "+sub)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		apiError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	handler(w, r, hash)
+}
+
+// linksPerPage is how many links /links/ and /api/v1/links show at once
+// unless per_page asks for something else, up to maxLinksPerPage
+const (
+	linksPerPage    = 50
+	maxLinksPerPage = 500
+)
+
+// A linkPage is one page of the links whose destination contains Query,
+// newest first
+type linkPage struct {
+	Links   []linkJSON `json:"links"`
+	Query   string     `json:"q,omitempty"`
+	Tag     string     `json:"tag,omitempty"`
+	Page    int        `json:"page"`
+	PerPage int        `json:"per_page"`
+	Total   int        `json:"total"`
+
+	// Admin is whether everyone's links are listed, along with who owns
+	//	them
+	Admin bool `json:"-"`
+
+	// TagCounts is every tag with its totals, for the /links/ page
+	TagCounts []tagCount `json:"-"`
+}
+
+func (p *linkPage) Pages() int {
+	return (p.Total + p.PerPage - 1) / p.PerPage
+}
+
+// pageQuery builds the query string for another page of the same search
+func (p *linkPage) pageQuery(page int) string {
+	v := url.Values{"page": {strconv.Itoa(page)}}
+	if p.Query != "" {
+		v.Set("q", p.Query)
+	}
+	if p.Tag != "" {
+		v.Set("tag", p.Tag)
+	}
+	if p.PerPage != linksPerPage {
+		v.Set("per_page", strconv.Itoa(p.PerPage))
+	}
+	return "?" + v.Encode()
+}
+
+func (p *linkPage) Prev() string {
+	if p.Page <= 1 {
+		return ""
+	}
+	return p.pageQuery(p.Page - 1)
+}
+
+func (p *linkPage) Next() string {
+	if p.Page >= p.Pages() {
+		return ""
+	}
+	return p.pageQuery(p.Page + 1)
+}
+
+// findLinks reads q, tag, page and per_page from the request and returns
+// that page of the links the requester can see
+func findLinks(r *http.Request) (*linkPage, error) {
+	q := r.URL.Query()
+	p := &linkPage{Query: strings.TrimSpace(q.Get("q")), Tag: strings.ToLower(strings.TrimSpace(q.Get("tag"))), Page: 1, PerPage: linksPerPage}
+	_, p.Admin = currentUser(r)
+	if page, err := strconv.Atoi(q.Get("page")); err == nil && page > 0 {
+		p.Page = page
+	}
+	if perPage, err := strconv.Atoi(q.Get("per_page")); err == nil && perPage > 0 {
+		p.PerPage = perPage
+	}
+	if p.PerPage > maxLinksPerPage {
+		p.PerPage = maxLinksPerPage
+	}
+
+	hashes, err := store.ListLinks()
+	if err != nil {
+		return nil, err
+	}
+	var matches []*Link
+	needle := strings.ToLower(p.Query)
+	for _, hash := range hashes {
+		l, err := store.LoadLink(hash)
+		if err != nil {
+			continue
+		}
+		matched := strings.Contains(strings.ToLower(l.Destination), needle) || strings.Contains(strings.ToLower(l.Title), needle)
+		if canSee(r, l) && matched && (p.Tag == "" || l.hasTag(p.Tag)) {
+			matches = append(matches, l)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if !matches[i].Created.Equal(matches[j].Created) {
+			return matches[i].Created.After(matches[j].Created)
+		}
+		return matches[i].Hash < matches[j].Hash
+	})
+
+	// summaries are only worked out for the page being shown
+	p.Total = len(matches)
+	p.Links = []linkJSON{}
+	start := (p.Page - 1) * p.PerPage
+	for i := start; i < len(matches) && i < start+p.PerPage; i++ {
+		s, err := cachedSummary(matches[i].Hash)
+		if err != nil {
+			return nil, err
+		}
+		p.Links = append(p.Links, newLinkJSON(r, matches[i], s))
+	}
+	return p, nil
+}
+
+func listLinks(w http.ResponseWriter, r *http.Request) {
+	p, err := findLinks(r)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// linksHandler serves the /links/ page
+func linksHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := findLinks(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.TagCounts, err = tagCounts(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = templates.ExecuteTemplate(w, "links.html", p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func createLink(w http.ResponseWriter, r *http.Request) {
+	var req linkRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	err = req.validate()
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	l, err := req.link()
+	if err == nil {
+		l.Owner, _ = currentUser(r)
+		var old *Link
+		if req.Slug == "" {
+			old, err = existingLink(r, l, req.Duplicate)
+		}
+		if old != nil {
+			s, err := cachedSummary(old.Hash)
+			if err != nil {
+				apiError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			j := newLinkJSON(r, old, s)
+			j.Existing = true
+			w.Header().Set("Location", appPath("/api/v1/links/"+old.Hash))
+			writeJSON(w, http.StatusOK, j)
+			return
+		}
+		if err == nil {
+			err = store.CreateLink(l)
+		}
+	}
+	if err == errCodeTaken {
+		apiError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	linksCreatedTotal.Add(1)
+
+	s, err := cachedSummary(l.Hash)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Location", appPath("/api/v1/links/"+l.Hash))
+	writeJSON(w, http.StatusCreated, newLinkJSON(r, l, s))
+}
+
+func showLink(w http.ResponseWriter, r *http.Request, hash string) {
+	l, err := store.LoadLink(hash)
+	if os.IsNotExist(err) {
+		apiError(w, http.StatusNotFound, "no such link")
+		return
+	} else if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s, err := cachedSummary(hash)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, newLinkJSON(r, l, s))
+}
+
+// patchLink changes a link's destination, taking {"destination": "..."}
+func patchLink(w http.ResponseWriter, r *http.Request, hash string) {
+	var req struct {
+		Destination *string   `json:"destination"`
+		Tags        *[]string `json:"tags"`
+		Title       *string   `json:"title"`
+		Notes       *string   `json:"notes"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.Destination == nil && req.Tags == nil && req.Title == nil && req.Notes == nil {
+		apiError(w, http.StatusBadRequest, "nothing to change; send a destination, tags, title or notes")
+		return
+	}
+	var destination string
+	if req.Destination != nil {
+		destination, _, err = normalizeDestination(*req.Destination)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.Tags != nil || req.Title != nil || req.Notes != nil {
+		u := &linkUpdate{Tags: req.Tags, Title: req.Title, Notes: req.Notes}
+		err = u.validate()
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		err = updateLink(hash, u)
+	}
+	if err == nil && req.Destination != nil {
+		err = setDestination(hash, destination)
+	}
+	if os.IsNotExist(err) {
+		apiError(w, http.StatusNotFound, "no such link")
+		return
+	} else if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	showLink(w, r, hash)
+}
+
+// deleteLink removes a link and its hits, or with ?archive=true only
+// archives it
+func deleteLink(w http.ResponseWriter, r *http.Request, hash string) {
+	archive, _ := strconv.ParseBool(r.URL.Query().Get("archive"))
+	var err error
+	if archive {
+		err = archiveLink(hash)
+	} else {
+		err = removeLink(hash)
+	}
+	if os.IsNotExist(err) {
+		apiError(w, http.StatusNotFound, "no such link")
+		return
+	} else if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if archive {
+		showLink(w, r, hash)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The pages for making and looking at links can be put behind a login,
+// with either one -admin-password or an htpasswd file of bcrypt hashed
+// users. /go/, /collect/ and the other public routes never need one.
+var (
+	adminPassword string
+	htpasswdUsers map[string]string // user -> bcrypt hash
+
+	// sessionSecret signs session cookies. Without one a random key is
+	// made at startup, so everyone has to log in again after a restart.
+	sessionSecret string
+	sessionTTL    = 7 * 24 * time.Hour
+
+	// loginLimit slows down password guessing
+	loginLimit, _ = parseRate("login", "10/1m")
+)
+
+const sessionCookie = "linkanalytics_session"
+
+var (
+	sessionKeyOnce sync.Once
+	sessionKeyData []byte
+)
+
+func sessionKey() []byte {
+	sessionKeyOnce.Do(func() {
+		if sessionSecret != "" {
+			sessionKeyData = []byte(sessionSecret)
+			return
+		}
+		sessionKeyData = make([]byte, 32)
+		rand.Read(sessionKeyData)
+	})
+	return sessionKeyData
+}
+
+func loginEnabled() bool {
+	return adminPassword != "" || len(htpasswdUsers) > 0
+}
+
+// loadHtpasswd reads "user:hash" lines, as made by htpasswd -B
+func loadHtpasswd(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(line, ":")
+		if !found || user == "" {
+			return nil, fmt.Errorf("%s:%d: want user:hash", filename, n)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: only bcrypt hashes (htpasswd -B) are supported", filename, n)
+		}
+		users[user] = hash
+	}
+	return users, scanner.Err()
+}
+
+// checkLogin returns who a user name and password log in as. The
+// -admin-password logs in as "admin" whatever the user name.
+func checkLogin(user, password string) (string, bool) {
+	if hash, found := htpasswdUsers[user]; found && bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+		return user, true
+	}
+	if adminPassword != "" && subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword)) == 1 {
+		return "admin", true
+	}
+	return "", false
+}
+
+func sessionSignature(user string, expires int64) []byte {
+	mac := hmac.New(sha256.New, sessionKey())
+	mac.Write([]byte(user + "\n" + strconv.FormatInt(expires, 10)))
+	return mac.Sum(nil)
+}
+
+// sessionUser returns who r's session cookie belongs to, if it has a valid
+// one
+func sessionUser(r *http.Request) (string, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	// user names can't have colons in them, because of htpasswd
+	user, rest, _ := strings.Cut(c.Value, ":")
+	exp, sig, _ := strings.Cut(rest, ":")
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", false
+	}
+	given, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(given, sessionSignature(user, expires)) {
+		return "", false
+	}
+	// a user taken out of the htpasswd file is logged out too
+	if _, found := htpasswdUsers[user]; !found && !(user == "admin" && adminPassword != "") {
+		return "", false
+	}
+	return user, true
+}
+
+func setSession(w http.ResponseWriter, r *http.Request, user string) {
+	expires := time.Now().Add(sessionTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    user + ":" + strconv.FormatInt(expires.Unix(), 10) + ":" + hex.EncodeToString(sessionSignature(user, expires.Unix())),
+		Path:     appPath("/"),
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		// Lax keeps the cookie off cross-site POSTs, so other sites
+		//	can't submit our forms as someone who's logged in
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// requireLogin sends people who aren't logged in to /login, when logins
+// are turned on
+func requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !loginEnabled() {
+			next(w, r)
+			return
+		}
+		if _, ok := sessionUser(r); ok {
+			next(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "log in first", http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, appPath("/login")+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	}
+}
+
+// safeNext is where to go after logging in: a path on this server, never
+// somewhere else
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return appPath("/create/")
+	}
+	return appPath(next)
+}
+
+// loginHandler serves GET and POST /login
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if !loginEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := struct {
+		Next     string
+		Users    bool // whether to ask for a user name
+		Wrong    bool
+		Username string
+	}{Next: r.FormValue("next"), Users: len(htpasswdUsers) > 0}
+	status := http.StatusOK
+
+	if r.Method == http.MethodPost {
+		ok, retry := loginLimit.allow(clientIP(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			http.Error(w, "too many login attempts; slow down", http.StatusTooManyRequests)
+			return
+		}
+		page.Username = r.PostFormValue("username")
+		if user, ok := checkLogin(page.Username, r.PostFormValue("password")); ok {
+			setSession(w, r, user)
+			log.Printf("audit: %s logged in as %s", r.RemoteAddr, user)
+			http.Redirect(w, r, safeNext(page.Next), http.StatusSeeOther)
+			return
+		}
+		log.Printf("audit: %s failed to log in as %q", r.RemoteAddr, page.Username)
+		page.Wrong = true
+		status = http.StatusUnauthorized
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	templates.ExecuteTemplate(w, "login.html", page)
+}
+
+// logoutHandler serves POST /logout
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "logging out needs a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: appPath("/"), MaxAge: -1})
+	http.Redirect(w, r, appPath("/login"), http.StatusSeeOther)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxHops is how many of our own short links a redirect may pass through
+// before we give up on it
+var maxHops = 5
+
+// internalHash returns the hash destination points at if it's one of our
+// own /go/ links
+func internalHash(r *http.Request, destination string) (string, bool) {
+	u, err := url.Parse(destination)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+
+	ours := strings.EqualFold(u.Host, r.Host)
+	if baseURL != "" {
+		base, err := url.Parse(baseURL)
+		ours = ours || err == nil && strings.EqualFold(u.Host, base.Host)
+	}
+	if !ours {
+		return "", false
+	}
+
+	rest, found := strings.CutPrefix(u.Path, appPath("/go/"))
+	if !found {
+		return "", false
+	}
+	hash, _, _ := strings.Cut(rest, "/")
+	return hash, validHash.MatchString(hash)
+}
+
+// loops reports whether following destination through our own links comes
+// back around to one already visited, or takes more than maxHops to leave.
+// Past the first hop it follows each link's main destination, since that's
+// the one every visitor eventually gets.
+func loops(r *http.Request, from string, destination string) bool {
+	seen := map[string]bool{from: true}
+	for hops := 0; ; hops++ {
+		hash, internal := internalHash(r, destination)
+		if !internal {
+			return false
+		}
+		if seen[hash] || hops >= maxHops {
+			return true
+		}
+		seen[hash] = true
+
+		l, err := store.LoadLink(hash)
+		if err != nil {
+			// a missing link is a 404, not a loop
+			return false
+		}
+		destination = l.Destination
+		if rs := rulesets[l.Ruleset]; rs != nil {
+			destination = rs.resolve(r, destination)
+		}
+	}
+}
@@ -1,164 +1,617 @@
 package main
 
 import (
-	"bufio"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 type Link struct {
-	Destination string
-	Hash        string
+	Destination string `json:"-"`
+	Hash        string `json:"-"`
+	Ruleset     string `json:"ruleset,omitempty"`
+
+	// Rules send visitors on some devices somewhere else, like an app
+	//	store, before any ruleset is looked at
+	Rules []Rule `json:"rules,omitempty"`
+
+	// GeoRules send visitors from some countries somewhere else, after
+	//	Rules and before any ruleset
+	GeoRules []GeoRule `json:"geo_rules,omitempty"`
+
+	// Schedule sends clicks somewhere else during windows of time, which
+	//	are in Timezone, or -timezone without one
+	Schedule []ScheduledDestination `json:"schedule,omitempty"`
+	Timezone string                 `json:"timezone,omitempty"`
+
+	// Created is missing on links made before it was recorded
+	Created time.Time `json:"created,omitempty"`
+
+	// Owner is the user who created the link, with logins turned on
+	Owner string `json:"owner,omitempty"`
+
+	// Tags group links, for listing, counting and changing them together
+	Tags []string `json:"tags,omitempty"`
+
+	// Title and Notes say what the link is for, for whoever made it
+	Title string `json:"title,omitempty"`
+	Notes string `json:"notes,omitempty"`
+
+	// Disabled links stop redirecting and counting until re-enabled
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Archived links stop redirecting for good but keep their analytics
+	Archived bool `json:"archived,omitempty"`
+
+	// Goal is a number of clicks to show progress toward (0 means none)
+	Goal int `json:"goal,omitempty"`
+
+	// Webhook is POSTed to for every hit on the link
+	Webhook string `json:"webhook,omitempty"`
+
+	// Mirrors are served in turn with Destination, one per click
+	Mirrors []string `json:"mirrors,omitempty"`
+
+	// Variants split clicks between destinations by weight, instead of
+	//	sending them to Destination
+	Variants []Variant `json:"variants,omitempty"`
+
+	// RedirectStatus is the status /go/ redirects with: 301, 302, 307 or
+	//	308 (0 means 302)
+	RedirectStatus int `json:"redirect_status,omitempty"`
+
+	// Interstitial shows the destination and asks before redirecting
+	Interstitial bool `json:"interstitial,omitempty"`
+
+	// PassQuery adds the query string a click came with to the
+	//	destination
+	PassQuery bool `json:"pass_query,omitempty"`
+
+	// Value is what one click is worth, for ROI reporting (0 means unset)
+	Value float64 `json:"value,omitempty"`
+
+	// PasswordHash is a bcrypt hash of the passphrase needed to follow the
+	//	link (empty means none is needed)
+	PasswordHash string `json:"password_hash,omitempty"`
+
+	// MaxClicks is how many clicks the link redirects before it's used up
+	//	(0 means no limit)
+	MaxClicks int `json:"max_clicks,omitempty"`
+
+	// Expires is when the link stops redirecting (zero means never)
+	Expires time.Time `json:"expires,omitempty"`
+
+	// IdleTTL makes a link stop working once it's gone this long without
+	//	a click
+	IdleTTL Duration `json:"idle_ttl,omitempty"`
+
+	// Fields overrides -redact for this link: true records a hit field
+	//	and false leaves it out
+	Fields map[string]bool `json:"fields,omitempty"`
+
+	// links in a workspace live in a subdirectory named after it; links
+	//	without one stay in the current directory
+	Workspace string `json:"-"`
+
+	// hits are appended to the link's file, so its modification time is
+	//	when the link was last clicked (or saved)
+	LastActive time.Time `json:"-"`
 }
 
 type LinkAnalytics struct {
 	GoTo      *Link
+	ShortURL  string
+	BeaconURL string
+	PixelURL  string
+	ScriptURL string // of /collect.js
+	Summary   *Summary
 	Analytics []byte
-}
 
-func newLink(destination string) *Link {
-	// we expect the destination URL to already have been stripped of extra
-	//	whitespace by this point
-	h := sha256.New()
-	h.Write([]byte(destination))
+	// Series is charted, and shown instead of the daily table when the
+	//	page is asked for ?granularity=hour or week
+	Series *Timeseries
 
-	hash := hex.EncodeToString(h.Sum(nil))
-	return &Link{Destination: destination, Hash: hash}
+	// Existing is set when creating a link found this one already going
+	//	to the same place
+	Existing bool
 }
 
-func (l *Link) save() error {
-	filename := l.Hash + ".linkanalytics"
-	contents := []byte(l.Destination + "\n")
-	return os.WriteFile(filename, contents, 0600)
+// TotalValue is what all of a link's clicks are worth
+func (a *LinkAnalytics) TotalValue() float64 {
+	return float64(a.Summary.Total) * a.GoTo.Value
 }
 
-func loadLink(hash string) (*Link, error) {
-	filename := hash + ".linkanalytics"
-	file, err := os.Open(filename)
+// ConversionValue is what a link's attributed conversions are worth, at its
+// value per click
+func (a *LinkAnalytics) ConversionValue() float64 {
+	return float64(a.Summary.Attributed) * a.GoTo.Value
+}
+
+func newLink(destination string) (*Link, error) {
+	// we expect the destination URL to already have been normalized by
+	//	this point, so the hash generator sees one spelling of it
+	hash, err := idGenerator.Next(destination)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-
-	// only the first line is the destination
-	scanner.Scan()
-	destination := scanner.Text()
-
-	return &Link{Destination: destination, Hash: hash}, nil
+	return &Link{Destination: destination, Hash: hash, Created: time.Now()}, nil
 }
 
-func loadHits(hash string) ([]byte, error) {
-	filename := hash + ".linkanalytics"
-	hits, err := os.ReadFile(filename)
+// redirectStatus is the status clicks on l are redirected with
+func (l *Link) redirectStatus() int {
+	if l.RedirectStatus == 0 {
+		return http.StatusFound
+	}
+	return l.RedirectStatus
+}
 
-	if err != nil {
-		return nil, err
+// validRedirectStatus reports whether a link can redirect with status. 0
+// picks the default.
+func validRedirectStatus(status int) bool {
+	switch status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
 	}
-	return hits, nil
+	return false
 }
 
-func gotHit(hash string, ua string) error {
-	filename := hash + ".linkanalytics"
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+// workspace names become directory names, so they can't contain anything
+// that would let them escape the data directory
+var validWorkspace = regexp.MustCompile("^[a-z0-9][a-z0-9_-]{0,63}$")
+
+func gotHit(hash string, h Hit) error {
+	err := store.AppendHit(hash, h)
 	if err != nil {
 		return err
 	}
+	invalidateSummary(hash)
+	return nil
+}
 
-	logger := log.New(file, "hit: ", log.LstdFlags)
-	logger.Println(ua) // add information about the user here later
-	defer file.Close()
+var templateFuncs = template.FuncMap{
+	"rulesets":  rulesetNames,
+	"path":      appPath,
+	"hitFields": func() []string { return hitFields },
+	"timeChart": timeChart,
+	"barChart":  barChart,
 
-	return nil
+	"loginEnabled": loginEnabled,
+
+	"attributionWindow": func() time.Duration { return attributionWindow },
 }
 
-var templates = template.Must(template.ParseFiles("create.html", "analytics.html"))
+var templateFiles = []string{"create.html", "analytics.html", "compare.html", "maintenance.html", "snapshot.html", "trending.html", "links.html", "edit.html", "expired.html", "password.html", "login.html", "interstitial.html"}
 
-func createHandler(w http.ResponseWriter, r *http.Request, m string) {
-	// m is ignored since we're just displaying the form
+var templates = template.Must(loadTemplates(""))
+
+// createForm is what create.html is filled in with, along with why saving
+// it failed when it's shown again after a bad submission
+type createForm struct {
+	*Link
+	Slug     string
+	ReturnTo string
+	Error    string
+}
+
+// prefilledForm reads the fields the create form can be filled in with
+// from get, which is a query or the submitted form. return_to is passed
+// along to /save/, which checks it.
+func prefilledForm(get func(string) string) createForm {
+	l := &Link{
+		Destination: strings.TrimSpace(get("destination")),
+		Workspace:   get("workspace"),
+		Ruleset:     get("ruleset"),
+		Tags:        parseTags(get("tags")),
+		Title:       get("title"),
+		Notes:       get("notes"),
+	}
+	for _, device := range []string{"ios", "android"} {
+		if d := strings.TrimSpace(get(device + "_destination")); d != "" {
+			l.Rules = append(l.Rules, Rule{Device: device, Destination: d})
+		}
+	}
+	l.GeoRules, _ = parseGeoRules(get("geo_rules"))
+	l.Schedule, _ = parseSchedule(get("schedule"))
+	l.Timezone = get("timezone")
+	if value, err := strconv.ParseFloat(get("value"), 64); err == nil && value >= 0 && !math.IsInf(value, 0) {
+		l.Value = value
+	}
+	return createForm{Link: l, Slug: get("slug"), ReturnTo: get("return_to")}
+}
 
-	// we don't need an actual link since our template never uses it
-	err := templates.ExecuteTemplate(w, "create.html", &Link{Destination: "", Hash: ""})
+func showCreateForm(w http.ResponseWriter, status int, form createForm) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err := templates.ExecuteTemplate(w, "create.html", form)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Print("rendering create.html: ", err)
 	}
 }
 
+// createFormError shows the create form again with what was submitted
+// and what was wrong with it, so it can be fixed rather than retyped
+func createFormError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	form := prefilledForm(r.FormValue)
+	form.Error = err.Error()
+	showCreateForm(w, status, form)
+}
+
+func createHandler(w http.ResponseWriter, r *http.Request, m string) {
+	// m is ignored since we're just displaying the form
+
+	// bookmarklets can link to /create/?destination=... to fill the form
+	//	in; nothing is checked until it's submitted to /save/
+	showCreateForm(w, http.StatusOK, prefilledForm(r.URL.Query().Get))
+}
+
 func saveHandler(w http.ResponseWriter, r *http.Request, m string) {
 	// m is ignored since we're processing form data from a POST request
-	destination := r.FormValue("destination")
-	l := newLink(destination)
-	err := l.save()
+	req, err := linkRequestFromForm(r)
 	if err != nil {
+		createFormError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	err = req.validate()
+	if err != nil {
+		createFormError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	l, err := req.link()
+	if err == errCodeTaken {
+		createFormError(w, r, http.StatusConflict, err)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	l.Owner, _ = currentUser(r)
+
+	// a link that already goes there is shown rather than made again
+	if req.Slug == "" {
+		old, err := existingLink(r, l, req.Duplicate)
+		if err == errCodeTaken {
+			createFormError(w, r, http.StatusConflict, err)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if old != nil {
+			target, err := createdRedirect(r.FormValue("return_to"), old.Hash)
+			if err != nil {
+				createFormError(w, r, http.StatusBadRequest, err)
+				return
+			}
+			http.Redirect(w, r, withExisting(target), http.StatusFound)
+			return
+		}
+	}
+
+	// checked before saving so a bad return_to doesn't leave a link
+	//	behind that nobody was shown
+	target, err := createdRedirect(r.FormValue("return_to"), l.Hash)
+	if err != nil {
+		createFormError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	// never SaveLink, which would update a link that already has a
+	//	custom slug and the same destination
+	err = store.CreateLink(l)
+	if err == errCodeTaken {
+		createFormError(w, r, http.StatusConflict, err)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(w, r, "/analytics/"+l.Hash, http.StatusFound)
+	linksCreatedTotal.Add(1)
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// rawHits is the plain dump of a link at the bottom of its analytics page
+func rawHits(l *Link, hits []Hit) []byte {
+	raw := []byte(l.Destination + "\n")
+	for _, h := range hits {
+		line, err := json.Marshal(h)
+		if err != nil {
+			continue
+		}
+		raw = append(raw, "hit: "...)
+		raw = append(raw, line...)
+		raw = append(raw, '\n')
+	}
+	return raw
 }
 
 func analyticsHandler(w http.ResponseWriter, r *http.Request, m string) {
-	l, err := loadLink(m)
+	if !allowLookup(w, r) {
+		return
+	}
+	l, err := store.LoadLink(m)
+	if os.IsNotExist(err) {
+		missedLookup(r)
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	h, err2 := loadHits(m)
+	hits, err2 := store.LoadHits(m)
 	if err2 != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err2.Error(), http.StatusInternalServerError)
 		return
 	}
+	h := rawHits(l, hits)
 
-	a := &LinkAnalytics{l, h}
+	sum, err4 := cachedSummary(m)
+	if err4 != nil {
+		http.Error(w, err4.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a := &LinkAnalytics{GoTo: l, ShortURL: shareableURL(r, l.Hash), BeaconURL: beaconURL(l.Hash), Summary: sum, Analytics: h}
+	a.PixelURL = absoluteURL(r, appPath("/pixel/"+l.Hash))
+	a.ScriptURL = absoluteURL(r, appPath("/collect.js"))
+	a.Existing = r.URL.Query().Get("existing") == "1"
+	a.Series, err = timeseries(hits, r.URL.Query().Get("granularity"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	err3 := templates.ExecuteTemplate(w, "analytics.html", a)
 	if err3 != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err3.Error(), http.StatusInternalServerError)
 	}
 }
 
 func goHandler(w http.ResponseWriter, r *http.Request, m string) {
-	l, err := loadLink(m)
+	if !allowLookup(w, r) {
+		return
+	}
+	l, err := store.LoadLink(m)
+	if os.IsNotExist(err) {
+		// codes that were rotated away keep working during their grace
+		//	period; the hit gets recorded when the new code is visited.
+		//	It's a 302 because browsers would keep following a 301
+		//	after the grace period is over.
+		a, found := loadAlias(m)
+		if found {
+			http.Redirect(w, r, shareableURL(r, a.To), http.StatusFound)
+			return
+		}
+		missedLookup(r)
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err2 := gotHit(l.Hash, r.Header.Get("User-Agent"))
-	if err2 != nil {
+	// checked before recording the hit, which would otherwise revive it
+	if l.lapsed() || l.Expired() {
+		serveExpired(w, expiredMessage)
+		return
+	}
+	if l.Disabled {
+		http.Error(w, "this link has been disabled", http.StatusGone)
+		return
+	}
+	if l.Archived {
+		http.Error(w, "this link has been archived", http.StatusGone)
+		return
+	}
+
+	// nothing is recorded until the passphrase is given
+	if !unlocked(w, r, l) {
+		return
+	}
+
+	// shown before a mirror or ruleset picks a destination, so it doesn't
+	//	use up a turn of the rotation
+	if l.showsInterstitial(r) {
+		serveInterstitial(w, r, l)
+		return
+	}
+
+	// rulesets are looked up by name on every redirect so that editing the
+	//	rulesets file changes every link that uses them
+	destination := l.nextDestination()
+	if d, found := l.scheduledDestination(time.Now()); found {
+		destination = d
+	}
+	variant := 0
+	if len(l.Variants) > 0 {
+		n := l.pickVariant(w, r)
+		destination = l.Variants[n].Destination
+		variant = n + 1
+	}
+	geoRule := 0
+	if d, found := l.deviceDestination(r); found {
+		destination = d
+	} else if d, n := l.geoDestination(visitorCountry(r, l)); n > 0 {
+		destination = d
+		geoRule = n
+	} else if l.Ruleset != "" {
+		rs := rulesets[l.Ruleset]
+		if rs != nil {
+			destination = rs.resolve(r, destination)
+		} else {
+			log.Printf("link %s uses missing ruleset %s", l.Hash, l.Ruleset)
+		}
+	}
+	if l.PassQuery {
+		// confirm is the interstitial's, not the destination's
+		q := r.URL.Query()
+		if l.Interstitial || interstitialAll {
+			q.Del("confirm")
+		}
+		destination = withQuery(destination, q)
+	}
+
+	// refused before anything is recorded, so it isn't counted as a
+	//	click or against MaxClicks
+	if httpsOnly && !strings.HasPrefix(strings.ToLower(destination), "https://") {
+		http.Error(w, errHTTPSRequired.Error(), http.StatusForbidden)
+		return
+	}
+
+	// which mirror a click went to is only worth recording when there's
+	//	more than one
+	h := newHit(r)
+	if l.records("visitor") {
+		var returning bool
+		h.Visitor, returning = visitorID(w, r)
+		if classifyVisitors && h.Visitor != "" {
+			h.Visit = "new"
+			if returning {
+				h.Visit = "returning"
+			}
+		}
+	}
+	if len(l.Mirrors) > 0 || variant > 0 || len(l.Rules) > 0 || len(l.GeoRules) > 0 || len(l.Schedule) > 0 {
+		h.Destination = destination
+	}
+	h.Variant = variant
+	h.GeoRule = geoRule
+
+	// a destination edited to point back at us could bounce a visitor
+	//	between our own links forever
+	looping := loops(r, l.Hash, destination)
+	if looping {
+		h.Error = "redirect loop"
+	}
+
+	// clicks past the limit are still recorded, but marked so they
+	//	don't count
+	used, err := overLimit(l)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.OverLimit = used
 
-	http.Redirect(w, r, l.Destination, http.StatusFound)
+	err2 := recordHit(l, h)
+	if err2 != nil {
+		http.Error(w, err2.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if used {
+		serveExpired(w, limitMessage)
+		return
+	}
+
+	if looping {
+		log.Printf("link %s redirects in a loop through %s", l.Hash, destination)
+		http.Error(w, "this link redirects in a loop", http.StatusLoopDetected)
+		return
+	}
+
+	// anything but a GET, like the passphrase form's POST, gets a 303: a
+	//	307 or 308 would have the browser send the form, passphrase and
+	//	all, on to the destination
+	status := l.redirectStatus()
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusSeeOther
+	}
+	redirectsTotal.Add(1)
+	http.Redirect(w, r, destination, status)
 }
 
 func collectHandler(w http.ResponseWriter, r *http.Request, m string) {
-	l, err := loadLink(m)
+	// beacons are either images (GET) or fetch/sendBeacon calls (POST)
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "beacons must use GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	l, err := store.LoadLink(m)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err2 := gotHit(l.Hash, r.Header.Get("User-Agent"))
+	if l.lapsed() {
+		http.Error(w, "this link expired after going unused", http.StatusGone)
+		return
+	}
+	if l.Expired() {
+		http.Error(w, "this link has expired", http.StatusGone)
+		return
+	}
+	if l.Disabled {
+		http.Error(w, "this link has been disabled", http.StatusGone)
+		return
+	}
+	if l.Archived {
+		http.Error(w, "this link has been archived", http.StatusGone)
+		return
+	}
+
+	// beacons without a nonce are always counted
+	nonce := r.URL.Query().Get("nonce")
+	if len(nonce) > 64 {
+		http.Error(w, "nonce is too long", http.StatusBadRequest)
+		return
+	}
+	if nonceWindow > 0 && nonce != "" && !consumeNonce(l.Hash, nonce) {
+		http.Error(w, "this beacon was already counted", http.StatusConflict)
+		return
+	}
+
+	h := newHit(r)
+	h.addPageview(r)
+	if l.records("visitor") {
+		h.Visitor, _ = visitorID(w, r)
+	}
+	if r.URL.Query().Get("conversion") == "1" {
+		h.Conversion = true
+		h.Attributed, err = attribute(l.Hash, h.Visitor, h.Time)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err2 := recordHit(l, h)
 	if err2 != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err2.Error(), http.StatusInternalServerError)
 		return
 	}
+	collectsTotal.Add(1)
 
 	fmt.Fprintf(w, "200 OK %s", m)
 }
 
 func validPathComponent(path string) []string {
-	validPath := regexp.MustCompile("^/(create|save|analytics|go|collect)/([a-zA-Z0-9]*)$")
-	return validPath.FindStringSubmatch(path)
+	validPath := regexp.MustCompile("^/(create|save|analytics|go|collect|pixel|edit|rotate|delete|share|qr|snapshot|api/hits|api/useragents|api/visitors|api/resolve)/([a-zA-Z0-9]*)(?:/([a-zA-Z0-9_-]{1,64}))?$")
+	m := validPath.FindStringSubmatch(path)
+
+	// only /go/ takes a suffix, and only if it's being used as the source
+	if m != nil && m[3] != "" && !(m[1] == "go" && sourceFromSuffix) {
+		return nil
+	}
+	return m
 }
 
 // Wraps handlers to remove the boilerplate of checking for valid URLs
@@ -173,23 +626,519 @@ func wrapHandler(fn func(http.ResponseWriter, *http.Request, string)) http.Handl
 	}
 }
 
+// listenUnix listens on a Unix socket for a reverse proxy on the same
+// machine, replacing a socket left behind by a previous run
+func listenUnix(path string) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the proxy usually runs as a different user in our group
+	err = os.Chmod(path, 0660)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
 func main() {
+	storage := flag.String("storage", "file", "where links and hits are kept: file, sqlite or postgres")
+	sqlitePath := flag.String("sqlite-path", "linkanalytics.db", "database file for -storage=sqlite")
+	postgresDSN := flag.String("postgres-dsn", "", "connection string for -storage=postgres (default: $DATABASE_URL)")
+	postgresConns := flag.Int("postgres-conns", 10, "most connections to keep open to PostgreSQL")
+	rulesetsFile := flag.String("rulesets", "", "JSON file of named redirect rulesets")
+	flag.IntVar(&linkCacheSize, "link-cache", linkCacheSize, "how many links to keep in memory for redirects (0 to not cache)")
+	flag.DurationVar(&linkCacheTTL, "link-cache-ttl", linkCacheTTL, "how long a cached link is trusted, for changes made by other instances sharing the database")
+	flag.IntVar(&summaryCacheSize, "summary-cache", summaryCacheSize, "how many links' summaries to keep in memory (0 to not cache)")
+	warm := flag.Int("warm", 0, "precompute summaries for this many recently active links on startup")
+	listenHost := flag.String("listen", os.Getenv("LINKANALYTICS_LISTEN"), "address to listen on (default $LINKANALYTICS_LISTEN, or every interface)")
+	port := flag.String("port", defaultPort(), "port to listen on (default $LINKANALYTICS_PORT, then $PORT, then 8080)")
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM certificate file to serve HTTPS with, along with -tls-key")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM private key file for -tls-cert")
+	flag.StringVar(&autocertDomains, "autocert-domains", "", "comma separated hosts to serve HTTPS for with certificates from Let's Encrypt (needs -port 443)")
+	flag.StringVar(&autocertCache, "autocert-cache", autocertCache, "directory to keep Let's Encrypt certificates and account keys in")
+	flag.StringVar(&autocertEmail, "autocert-email", "", "contact address for Let's Encrypt expiry and problem notices")
+	flag.StringVar(&httpRedirect, "http-redirect", "", "address (like :80) to also listen on with plain HTTP, redirecting to HTTPS")
+	flag.StringVar(&baseURL, "base-url", os.Getenv("LINKANALYTICS_BASE_URL"), "scheme and host to build short links with, like https://sho.rt (default $LINKANALYTICS_BASE_URL, or the request's host)")
+	flag.StringVar(&basePath, "base-path", "", "path prefix to serve every route under")
+	flag.BoolVar(&customDomains, "custom-domains", false, "build short links from the request's host even if -base-url is set")
+	redact := flag.String("redact", "", "comma separated hit fields ("+strings.Join(hitFields, ", ")+") not to record unless a link asks for them")
+	flag.StringVar(&apiToken, "api-token", "", "bearer token required by the JSON API (default: no auth)")
+	flag.StringVar(&adminPassword, "admin-password", "", "password for logging in to the pages that create links and show analytics (default: no login)")
+	htpasswd := flag.String("htpasswd", "", "file of users allowed to log in, with bcrypt passwords as made by htpasswd -B")
+	flag.StringVar(&sessionSecret, "session-secret", "", "key for signing login sessions (default: random, so logins end on restart)")
+	flag.DurationVar(&sessionTTL, "session-ttl", sessionTTL, "how long a login lasts")
+	adminUsers := flag.String("admins", "", "comma separated users who can see and change everyone's links, besides the -admin-password login")
+	flag.StringVar(&jwtSecret, "jwt-secret", "", "accept HS256 JWTs signed with this secret on the JSON API")
+	flag.StringVar(&jwksURL, "jwks-url", "", "accept RS256 JWTs signed by the keys at this JWKS URL on the JSON API")
+	flag.StringVar(&jwtScope, "jwt-scope", "", "scope a JWT needs to use API routes other than GET")
+	outboundWorkers := flag.Int("outbound-workers", 4, "goroutines used for webhooks and other outbound requests")
+	hitBuffer := flag.Int("hit-buffer", 1024, "hits that can wait to be written in the background (0 to write each before redirecting)")
+	outboundQueue := flag.Int("outbound-queue", 1000, "outbound jobs that can wait before the oldest are dropped")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait at exit for requests in flight and queued webhooks")
+	sweepInterval := flag.Duration("sweep-interval", time.Hour, "how often to delete links that expired from disuse (0 to never)")
+	flag.BoolVar(&sourceFromSuffix, "source-suffix", false, "record the last part of /go/<hash>/<source> as the hit's source")
+	flag.StringVar(&sourceParam, "source-param", "", "query parameter to record as the hit's source")
+	flag.BoolVar(&canonicalHosts, "canonical-hosts", true, "lowercase destination hosts and drop default ports")
+	flag.BoolVar(&interstitialAll, "interstitial", false, "show every link's destination and ask before redirecting, not just links that ask for it")
+	flag.BoolVar(&httpsOnly, "https-only-destinations", false, "only allow links to https:// destinations")
+	allowHosts := flag.String("allow-hosts", "", "comma separated hosts that links may go to, along with their subdomains (default: any)")
+	denyHosts := flag.String("deny-hosts", "", "comma separated hosts that links may not go to, along with their subdomains")
+	flag.BoolVar(&denyShortLinks, "deny-short-links", false, "refuse destinations on well known URL shorteners or on -base-url's host")
+	flag.DurationVar(&nonceWindow, "nonce-window", 0, "count a /collect/ beacon's ?nonce= only once within this long (0 to turn off)")
+	ids := flag.String("ids", "random", "how new links get their codes: random (short base62 codes) or hash (of the destination)")
+	flag.StringVar(&snapshotSecret, "snapshot-secret", "", "key for signing snapshot URLs (default: random, so they stop working on restart)")
+	rateSave := flag.String("rate-save", "", "requests to /save/ each IP may make, like 30/1m, all at once if it likes (default: no limit)")
+	rateGo := flag.String("rate-go", "", "clicks on /go/ each IP may make, like 120/1m (default: no limit)")
+	timezone := flag.String("timezone", "", "time zone, like Europe/Berlin, that scheduled destinations are in for links without their own (default: the server's)")
+	cors := flag.String("cors-origins", "", "comma separated origins, like https://example.com, whose pages may call /collect/ and the API with fetch(), or * for any")
+	rateCollect := flag.String("rate-collect", "", "beacons to /collect/ each IP may send, like 120/1m (default: no limit)")
+	flag.IntVar(&maxMisses, "max-misses", maxMisses, "lookups of nonexistent links an IP may make per minute before getting 429s (0 for no limit)")
+	flag.DurationVar(&attributionWindow, "attribution-window", attributionWindow, "how long after a click a conversion by the same visitor is credited to it")
+	flag.DurationVar(&trendingHalfLife, "trending-half-life", trendingHalfLife, "how long it takes a click to count half as much toward trending")
+	flag.DurationVar(&trendingTTL, "trending-cache", trendingTTL, "how long to reuse the trending ranking before recomputing it")
+	flag.BoolVar(&recordTLS, "record-tls", false, "record the TLS version, cipher suite and ALPN protocol of hits served over TLS")
+	flag.StringVar(&afterCreate, "after-create", afterCreate, "where to go after creating a link; {hash} is replaced by its hash")
+	geoipDB := flag.String("geoip-db", "", "MaxMind GeoLite2 or GeoIP2 .mmdb file for tagging hits with their country and city")
+	proxies := flag.String("trusted-proxies", "", "comma separated addresses or CIDR ranges of proxies whose X-Forwarded-For to believe")
+	flag.StringVar(&anonymizeIP, "anonymize-ip", "", "store hit IPs truncated (truncate) or as salted hashes (hash) instead of whole")
+	flag.StringVar(&ipSalt, "ip-salt", os.Getenv("LINKANALYTICS_IP_SALT"), "salt for -anonymize-ip=hash (default $LINKANALYTICS_IP_SALT, or random so hashes change on restart)")
+	returnHosts := flag.String("return-to-hosts", "", "comma separated hosts a return_to parameter may send people to after creating a link")
+	flag.StringVar(&expiredMessage, "expired-message", expiredMessage, "what visitors are told when a link has expired")
+	flag.StringVar(&limitMessage, "limit-message", limitMessage, "what visitors are told when a link has used up its click limit")
+	flag.BoolVar(&countBots, "count-bots", false, "count clicks from crawlers and link previews as clicks instead of setting them apart")
+	flag.BoolVar(&classifyVisitors, "classify-visitors", false, "mark clicks on /go/ as from new or returning visitors, going by the visitor cookie")
+	flag.StringVar(&goalWebhook, "goal-webhook", "", "URL to POST to when a link reaches its click goal")
+	flag.StringVar(&hitWebhook, "hit-webhook", "", "URL to POST every hit on every link to")
+	flag.StringVar(&webhookSecret, "webhook-secret", os.Getenv("LINKANALYTICS_WEBHOOK_SECRET"), "key to sign hit webhooks with in their X-Signature header (default $LINKANALYTICS_WEBHOOK_SECRET)")
+	flag.IntVar(&maxHops, "max-hops", maxHops, "how many of this server's own links a redirect may go through before it's treated as a loop")
+	flag.BoolVar(&maintenance, "maintenance", false, "answer everything but /healthz with 503 Service Unavailable")
+	flag.StringVar(&maintenanceFile, "maintenance-file", "", "be in maintenance whenever this file exists")
+	flag.StringVar(&maintenanceMessage, "maintenance-message", maintenanceMessage, "message shown during maintenance")
+	flag.DurationVar(&maintenanceRetryAfter, "maintenance-retry-after", maintenanceRetryAfter, "how long clients are told to wait during maintenance")
+	keyFile := flag.String("encryption-key-file", "", "file holding a base64 AES-256 key to encrypt destinations at rest with (default: $LINKANALYTICS_KEY, if set)")
+	flag.BoolVar(&encryptHits, "encrypt-hits", false, "encrypt recorded hits too, not just destinations")
+	unixSocket := flag.String("unix-socket", "", "listen on this Unix socket instead of TCP")
+	report := flag.String("report", "", "print the stats for this link's hash and exit")
+	reportJSON := flag.Bool("json", false, "print -report output as JSON")
+	flag.StringVar(&apiKeysFile, "api-keys", apiKeysFile, "file issued API keys are kept in, hashed, with -storage=file (the database keeps them otherwise)")
+	newAPIKey := flag.String("new-api-key", "", "issue an API key with this name, print it and exit")
+	apiKeyScope := flag.String("api-key-scope", scopeFull, "scope of the key from -new-api-key: full, read or create")
+	revokeKey := flag.String("revoke-api-key", "", "revoke the API key with this id and exit")
+	listKeys := flag.Bool("list-api-keys", false, "list issued API keys and exit")
+	exportTo := flag.String("export", "", "write every link and its hits to this JSON file (- for stdout) and exit")
+	importFrom := flag.String("import", "", "add the links in this JSON file from -export (- for stdin) and exit")
+	configFile := flag.String("config", os.Getenv("LINKANALYTICS_CONFIG"), "TOML file of flag settings; flags and $LINKANALYTICS_<FLAG> variables override it")
+	flag.StringVar(&templatesDir, "templates-dir", "", "directory of customized templates, each used in place of the built-in one with the same name")
+	dataDir := flag.String("data-dir", "", "directory to keep links and other state in, and to resolve relative paths from (default: the current directory)")
+	flag.Parse()
+
+	err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *dataDir != "" {
+		err = os.Chdir(*dataDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *htpasswd != "" {
+		htpasswdUsers, err = loadHtpasswd(*htpasswd)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	admins = parseAdmins(*adminUsers)
+	if templatesDir != "" {
+		templates, err = loadTemplates(templatesDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	atRest, err = loadAtRest(*keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if encryptHits && atRest == nil {
+		log.Fatal("-encrypt-hits needs an encryption key")
+	}
+
+	switch *storage {
+	case "file":
+		moved, err := shardLinkFiles()
+		if err != nil {
+			log.Fatal("sharding link files: ", err)
+		}
+		if moved > 0 {
+			log.Printf("moved %d link files into shard directories", moved)
+		}
+	case "sqlite":
+		store, err = openSQLiteStore(*sqlitePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "postgres":
+		dsn := *postgresDSN
+		if dsn == "" {
+			dsn = os.Getenv("DATABASE_URL")
+		}
+		store, err = openPostgresStore(dsn, *postgresConns)
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown -storage %q (want file, sqlite or postgres)", *storage)
+	}
+	if linkCacheSize > 0 {
+		store = newCachedStore(store)
+	}
+	store = meteredStore{store}
+
+	if *report != "" {
+		err := printReport(os.Stdout, *report, *reportJSON)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *newAPIKey != "" || *revokeKey != "" || *listKeys {
+		err := runAPIKeyCommand(*newAPIKey, *apiKeyScope, *revokeKey, *listKeys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *exportTo != "" {
+		err := runExport(*exportTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *importFrom != "" {
+		err := runImport(*importFrom)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	basePath = cleanBasePath(basePath)
+	allowedHosts, deniedHosts = parseHosts(*allowHosts), parseHosts(*denyHosts)
+	saveLimit, err = parseRate("link creation", *rateSave)
+	if err != nil {
+		log.Fatal("-rate-save: ", err)
+	}
+	goLimit, err = parseRate("click", *rateGo)
+	if err != nil {
+		log.Fatal("-rate-go: ", err)
+	}
+	collectLimit, err = parseRate("beacon", *rateCollect)
+	if err != nil {
+		log.Fatal("-rate-collect: ", err)
+	}
+	if *timezone != "" {
+		scheduleTimezone, err = time.LoadLocation(*timezone)
+		if err != nil {
+			log.Fatal("-timezone: ", err)
+		}
+	}
+	corsOrigins, err = parseCORSOrigins(*cors)
+	if err != nil {
+		log.Fatal("-cors-origins: ", err)
+	}
+	err = checkBaseURL(baseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	addr, err := listenAddress(*listenHost, *port)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, host := range strings.Split(*returnHosts, ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host != "" {
+			returnToHosts[host] = true
+		}
+	}
+
+	err = parseTrustedProxies(*proxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = validAnonymizeIP(anonymizeIP)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *geoipDB != "" {
+		err = openGeoIP(*geoipDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if recordTLS {
+		enrichmentNamed("TLS versions").err = nil
+	}
+
+	idGenerator, err = idGeneratorNamed(*ids)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, field := range strings.Split(*redact, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		known := false
+		for _, f := range hitFields {
+			known = known || f == field
+		}
+		if !known {
+			log.Fatalf("-redact: unknown hit field %q", field)
+		}
+		redacted[field] = true
+	}
+
+	if *rulesetsFile != "" {
+		sets, err := loadRulesets(*rulesetsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rulesets = sets
+	}
+
 	// Contains a form to create a new Link
 	//	(this handler does not care about the rest of the URL)
-	http.HandleFunc("/create/", wrapHandler(createHandler))
+	adminRoute("/create/", wrapHandler(createHandler), "GET")
 
 	// Handles form submissions on /create/
-	http.HandleFunc("/save/", wrapHandler(saveHandler))
+	adminRoute("/save/", rateLimited(saveLimit, wrapHandler(saveHandler)), "POST")
 
 	// Displays analytics for an already-created Link and redirects to /create/
 	//	if it doesn't exist yet
-	http.HandleFunc("/analytics/", wrapHandler(analyticsHandler))
+	adminRoute("/analytics/", wrapHandler(ownLink(analyticsHandler)), "GET")
 
 	// Redirects to the page and collects analytics data
-	http.HandleFunc("/go/", wrapHandler(goHandler))
+	route("/go/", rateLimited(goLimit, wrapHandler(goHandler)), "GET", "POST")
+
+	// The script pages embed to report their pageviews to /collect/
+	route("/collect.js", collectJSHandler, "GET")
+
+	// Records an open and serves a 1x1 GIF, for tracking emails
+	route("/pixel/", rateLimited(collectLimit, wrapHandler(pixelHandler)), "GET")
+
+	// Collects analytics data without redirecting, from pages on other
+	//	sites too with -cors-origins
+	route("/collect/", allowCORS(rateLimited(collectLimit, wrapHandler(collectHandler)), "GET", "POST"), "GET", "POST")
+
+	// Gives a link a new code, optionally keeping the old one as a redirect
+	authRoute("/rotate/", wrapHandler(ownLink(rotateHandler)), "POST")
+	authRoute("/delete/", wrapHandler(ownLink(deleteHandler)), "POST")
+	authRoute("/edit/", wrapHandler(ownLink(editHandler)), "GET", "POST")
+	route("/qr/", wrapHandler(qrHandler), "GET")
+
+	// Shows several links' analytics side by side
+	adminRoute("/compare", compareHandler, "GET")
+
+	// With -admin-password or -htpasswd, the pages above need a login
+	route("/login", loginHandler, "GET", "POST")
+	route("/logout", logoutHandler, "POST")
+
+	// Returns a link's hits as JSON, filtered and sorted by query parameters
+	apiRoute("/api/hits/", wrapHandler(ownLink(apiHitsHandler)), "GET")
+
+	// Returns a link's browser, OS and device breakdowns as JSON
+	apiRoute("/api/useragents/", wrapHandler(ownLink(apiUserAgentsHandler)), "GET")
+
+	// Says where a link goes without recording a click, with an ETag
+	apiRoute("/api/resolve/", wrapHandler(ownLink(apiResolveHandler)), "GET")
+
+	// Returns a link's new and returning visitors as JSON, per day
+	apiRoute("/api/visitors/", wrapHandler(ownLink(apiVisitorsHandler)), "GET")
+
+	// Checks a destination without creating a link
+	apiRoute("/api/validate", validateHandler, "POST")
+
+	// Creates, lists, shows, edits and deletes links as JSON
+	apiRoute("/api/v1/links", apiLinksHandler, "GET", "POST")
+	apiRoute("/api/v1/links/", apiLinksHandler, "GET", "PATCH", "DELETE")
+
+	// Counts links and clicks by tag
+	apiRoute("/api/v1/tags", apiTagsHandler, "GET")
+
+	// Issues, lists and revokes API keys
+	apiRoute("/api/v1/keys", adminOnly(apiKeysHandler), "GET", "POST")
+	apiRoute("/api/v1/keys/", adminOnly(apiKeysHandler), "DELETE")
+
+	// Backs up everything, or restores a backup, as one JSON archive
+	apiRoute("/api/v1/export", adminOnly(exportHandler), "GET")
+	apiRoute("/api/v1/import", adminOnly(importHandler), "POST")
+
+	// Applies the same change to many links at once
+	apiRoute("/api/links/bulk-update", bulkUpdateHandler, "POST")
+
+	// Signs a read-only, expiring URL for a link's analytics
+	apiRoute("/share/", wrapHandler(ownLink(shareHandler)), "POST")
+
+	// Shows the analytics a /share/ URL was signed for
+	route("/snapshot/", wrapHandler(snapshotHandler), "GET")
+
+	// Reports whether the service is up, even during maintenance
+	route("/healthz", healthzHandler, "GET")
 
-	// Collects analytics data without redirecting
-	http.HandleFunc("/collect/", wrapHandler(collectHandler))
+	// Reports whether we can serve traffic: storage is writable, templates
+	//	are loaded and we aren't in maintenance
+	route("/readyz", readyzHandler, "GET")
 
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	// Counters and request durations for Prometheus, which also keep
+	//	working during maintenance
+	route("/metrics", metricsHandler, "GET")
+
+	// A SimpleJSON datasource for Grafana
+	apiRoute("/grafana/", adminOnly(grafanaHandler), "GET", "POST")
+
+	// Lists and searches every link, or only your own when logged in
+	//	without being an admin. Listing links gives their hashes away, so
+	//	this needs the API token.
+	apiRoute("/links/", linksHandler, "GET")
+
+	// Ranks links by recent clicks, with older clicks counting for less.
+	//	Listing links gives their hashes away, so these need the API token.
+	apiRoute("/trending", trendingHandler, "GET")
+	apiRoute("/api/trending", apiTrendingHandler, "GET")
+
+	// Lists every route, its methods and whether it needs a token
+	route("/api/routes", routesHandler, "GET")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *outboundWorkers < 1 || *outboundQueue < 1 {
+		log.Fatal("-outbound-workers and -outbound-queue must be at least 1")
+	}
+	outbound = newWorkerPool(*outboundWorkers, *outboundQueue)
+	if *hitBuffer < 0 {
+		log.Fatal("-hit-buffer can't be negative")
+	}
+	if *hitBuffer > 0 {
+		hitQueue = newHitWriter(*hitBuffer)
+	}
+
+	var handler http.Handler = withMaintenance(mux)
+	if basePath != "" {
+		handler = http.StripPrefix(basePath, handler)
+	}
+
+	server := &http.Server{Addr: addr, Handler: handler}
+	redirect, err := setupTLS(server)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *unixSocket != "" {
+		logStartup("unix:" + *unixSocket)
+	} else {
+		logStartup(server.Addr)
+	}
+	go func() {
+		var listener net.Listener
+		var err error
+		if *unixSocket != "" {
+			listener, err = listenUnix(*unixSocket)
+		} else {
+			listener, err = net.Listen("tcp", server.Addr)
+		}
+		if err == nil {
+			if server.TLSConfig != nil {
+				// the files are empty with autocert, which is how
+				//	ServeTLS knows to use the config's certificates
+				err = server.ServeTLS(listener, tlsCert, tlsKey)
+			} else {
+				err = server.Serve(listener)
+			}
+		}
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	var redirectServer *http.Server
+	if httpRedirect != "" {
+		redirectServer = &http.Server{Addr: httpRedirect, Handler: redirect}
+		go func() {
+			err := redirectServer.ListenAndServe()
+			if err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if *sweepInterval > 0 {
+		go sweepLapsed(ctx, *sweepInterval)
+	}
+
+	// warming happens after we start serving so it never delays startup
+	if *warm > 0 {
+		go warmSummaries(ctx, *warm)
+	}
+
+	<-ctx.Done()
+	// a second signal kills us straight away
+	stop()
+	log.Printf("shutting down, waiting up to %s for requests in flight", *shutdownTimeout)
+
+	// every wait below shares the one deadline
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if redirectServer != nil {
+		redirectServer.Close()
+	}
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Print("requests still in flight at exit: ", err)
+		server.Close()
+	}
+	if *unixSocket != "" {
+		// closing the listener normally does this already
+		os.Remove(*unixSocket)
+	}
+
+	// no more hits can be queued once the requests are done, so write out
+	//	the ones waiting. This comes before the outbound pool closes since
+	//	writing a hit can queue a webhook.
+	if hitQueue != nil {
+		err = hitQueue.close(shutdownCtx)
+		if err != nil {
+			log.Print("hits still queued at exit: ", err)
+		}
+	}
+
+	// give queued outbound work whatever time is left to finish
+	err = outbound.close(shutdownCtx)
+	if err != nil {
+		log.Print("outbound jobs still queued at exit: ", err)
+	}
+
+	err = store.Close()
+	if err != nil {
+		log.Print("closing storage: ", err)
+	}
+	log.Print("stopped")
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	// maintenance takes the whole service offline; maintenanceFile does
+	// the same whenever that file exists, so it can be toggled without a
+	// restart
+	maintenance     bool
+	maintenanceFile string
+
+	maintenanceMessage    = "We're doing some maintenance and will be back shortly."
+	maintenanceRetryAfter = 5 * time.Minute
+)
+
+func inMaintenance() bool {
+	if maintenance {
+		return true
+	}
+	if maintenanceFile != "" {
+		_, err := os.Stat(maintenanceFile)
+		return err == nil
+	}
+	return false
+}
+
+// withMaintenance answers every request except /healthz, /readyz and
+// /metrics with a 503 while the service is in maintenance
+func withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" || !inMaintenance() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(maintenanceRetryAfter.Seconds())))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		templates.ExecuteTemplate(w, "maintenance.html", maintenanceMessage)
+	})
+}
+
+// healthzHandler reports whether we're up, and whether we're in
+// maintenance, for load balancers and monitoring
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	if inMaintenance() {
+		status = "maintenance"
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": status})
+}
+
+// readyzHandler answers 200 if we can take traffic and 503 if a load
+// balancer should send it elsewhere. Unlike /healthz it touches storage, so
+// it's meant for readiness probes rather than liveness ones: a full disk
+// shouldn't get the process restarted.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"storage": "ok", "templates": "ok"}
+	ready := true
+
+	err := store.Writable()
+	if err != nil {
+		checks["storage"] = err.Error()
+		ready = false
+	}
+	for _, name := range templateFiles {
+		if templates.Lookup(name) == nil {
+			checks["templates"] = name + " isn't loaded"
+			ready = false
+			break
+		}
+	}
+
+	status := "ok"
+	if !ready {
+		status = "unavailable"
+	} else if inMaintenance() {
+		status = "maintenance"
+		ready = false
+	}
+
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]any{"status": status, "checks": checks})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// /metrics serves counters in the Prometheus text format. It's written by
+// hand rather than with the client library since there are only a few of
+// them; the expvar numbers (summary cache, outbound queue, warmup) are
+// included as well.
+
+var (
+	redirectsTotal    atomic.Int64
+	collectsTotal     atomic.Int64
+	pixelsTotal       atomic.Int64
+	linksCreatedTotal atomic.Int64
+
+	// storage errors by Store method. Missing links and taken codes are
+	// answers, not errors, so they aren't counted.
+	storageErrors sync.Map // method name -> *atomic.Int64
+)
+
+// durationBuckets are the upper bounds, in seconds, of the request duration
+// histogram
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []int64 // one per bucket, not cumulative
+	count  int64
+	sum    float64
+}
+
+var (
+	durationsMu sync.Mutex
+	durations   = map[string]*histogram{} // by route pattern
+)
+
+func observeDuration(handler string, d time.Duration) {
+	durationsMu.Lock()
+	h := durations[handler]
+	if h == nil {
+		h = &histogram{counts: make([]int64, len(durationBuckets))}
+		durations[handler] = h
+	}
+	durationsMu.Unlock()
+
+	seconds := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// timed records how long handler takes under the route pattern it was
+// registered with
+func timed(pattern string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		handler(w, r)
+		observeDuration(pattern, time.Since(start))
+	}
+}
+
+func countStorageError(method string, err error) {
+	if err == nil || os.IsNotExist(err) || os.IsExist(err) || errors.Is(err, errCodeTaken) {
+		return
+	}
+	c, _ := storageErrors.LoadOrStore(method, new(atomic.Int64))
+	c.(*atomic.Int64).Add(1)
+}
+
+// meteredStore counts the errors of the Store it wraps
+type meteredStore struct {
+	Store
+}
+
+func (s meteredStore) SaveLink(l *Link) error {
+	err := s.Store.SaveLink(l)
+	countStorageError("SaveLink", err)
+	return err
+}
+
+func (s meteredStore) CreateLink(l *Link) error {
+	err := s.Store.CreateLink(l)
+	countStorageError("CreateLink", err)
+	return err
+}
+
+func (s meteredStore) LoadLink(hash string) (*Link, error) {
+	l, err := s.Store.LoadLink(hash)
+	countStorageError("LoadLink", err)
+	return l, err
+}
+
+func (s meteredStore) AppendHit(hash string, h Hit) error {
+	err := s.Store.AppendHit(hash, h)
+	countStorageError("AppendHit", err)
+	return err
+}
+
+func (s meteredStore) LoadHits(hash string) ([]Hit, error) {
+	hits, err := s.Store.LoadHits(hash)
+	countStorageError("LoadHits", err)
+	return hits, err
+}
+
+func (s meteredStore) ListLinks() ([]string, error) {
+	hashes, err := s.Store.ListLinks()
+	countStorageError("ListLinks", err)
+	return hashes, err
+}
+
+func (s meteredStore) DeleteLink(hash string) error {
+	err := s.Store.DeleteLink(hash)
+	countStorageError("DeleteLink", err)
+	return err
+}
+
+func (s meteredStore) RenameLink(hash, code string) error {
+	err := s.Store.RenameLink(hash, code)
+	countStorageError("RenameLink", err)
+	return err
+}
+
+func (s meteredStore) SetDestination(hash, destination string) error {
+	err := s.Store.SetDestination(hash, destination)
+	countStorageError("SetDestination", err)
+	return err
+}
+
+// metricsHandler serves GET /metrics
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	counter := func(name, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	counter("linkanalytics_redirects_total", "Clicks on /go/ that were redirected.", redirectsTotal.Load())
+	counter("linkanalytics_collects_total", "Beacons recorded on /collect/.", collectsTotal.Load())
+	counter("linkanalytics_pixels_total", "Tracking pixel loads recorded on /pixel/.", pixelsTotal.Load())
+	counter("linkanalytics_links_created_total", "Links created from the form or the API.", linksCreatedTotal.Load())
+
+	fmt.Fprint(w, "# HELP linkanalytics_storage_errors_total Failed calls to the store, by method.\n# TYPE linkanalytics_storage_errors_total counter\n")
+	var methods []string
+	storageErrors.Range(func(k, v any) bool {
+		methods = append(methods, k.(string))
+		return true
+	})
+	sort.Strings(methods)
+	for _, method := range methods {
+		c, _ := storageErrors.Load(method)
+		fmt.Fprintf(w, "linkanalytics_storage_errors_total{method=%q} %d\n", method, c.(*atomic.Int64).Load())
+	}
+
+	fmt.Fprint(w, "# HELP linkanalytics_request_duration_seconds How long requests took, by route.\n# TYPE linkanalytics_request_duration_seconds histogram\n")
+	durationsMu.Lock()
+	var handlers []string
+	for handler := range durations {
+		handlers = append(handlers, handler)
+	}
+	durationsMu.Unlock()
+	sort.Strings(handlers)
+	for _, handler := range handlers {
+		durationsMu.Lock()
+		h := durations[handler]
+		durationsMu.Unlock()
+
+		h.mu.Lock()
+		var cumulative int64
+		for i, bound := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "linkanalytics_request_duration_seconds_bucket{handler=%q,le=\"%g\"} %d\n", handler, bound, cumulative)
+		}
+		fmt.Fprintf(w, "linkanalytics_request_duration_seconds_bucket{handler=%q,le=\"+Inf\"} %d\n", handler, h.count)
+		fmt.Fprintf(w, "linkanalytics_request_duration_seconds_sum{handler=%q} %g\n", handler, h.sum)
+		fmt.Fprintf(w, "linkanalytics_request_duration_seconds_count{handler=%q} %d\n", handler, h.count)
+		h.mu.Unlock()
+	}
+
+	// expvar's own numbers, as gauges since some of them go down
+	expvar.Do(func(kv expvar.KeyValue) {
+		var value string
+		switch v := kv.Value.(type) {
+		case *expvar.Int, *expvar.Float:
+			value = v.String()
+		case expvar.Func:
+			switch n := v.Value().(type) {
+			case int, int64, float64:
+				value = fmt.Sprint(n)
+			}
+		}
+		if value == "" {
+			return
+		}
+		name := "linkanalytics_" + kv.Key
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %s\n", name, name, value)
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+import "sync"
+
+// cursors remember where each link with mirrors is in its rotation. They
+// live in memory, so a restart starts every rotation over.
+var cursors = struct {
+	sync.Mutex
+	m map[string]int
+}{m: map[string]int{}}
+
+// nextDestination cycles through a link's destination and its mirrors in
+// order, unlike a ruleset's Split, which picks at random
+func (l *Link) nextDestination() string {
+	if len(l.Mirrors) == 0 {
+		return l.Destination
+	}
+
+	cursors.Lock()
+	n := cursors.m[l.Hash] % (len(l.Mirrors) + 1)
+	cursors.m[l.Hash] = n + 1
+	cursors.Unlock()
+
+	if n == 0 {
+		return l.Destination
+	}
+	return l.Mirrors[n-1]
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// nonceWindow, when positive, makes /collect/ count a beacon carrying a
+// ?nonce= only once within that window, since email clients often fetch
+// the same image more than once
+var nonceWindow time.Duration
+
+// maxNonces bounds how many consumed nonces we remember. Past it the
+// oldest is forgotten, so a flood of made-up nonces can only make a replay
+// count twice, the same as when the shared state can't be reached, and
+// never gets real beacons turned away.
+const maxNonces = 100000
+
+var nonces = struct {
+	sync.Mutex
+	seen  map[string]time.Time // hash + nonce -> when it can be used again
+	order []usedNonce          // oldest first, which is also soonest to expire
+}{seen: map[string]time.Time{}}
+
+type usedNonce struct {
+	key     string
+	expires time.Time
+}
+
+// consumeNonce reports whether a nonce is fresh for a link, remembering it
+// if it is. Nonces are remembered in the store's shared state when it has
+// one, so a beacon replayed to another instance is caught too.
+func consumeNonce(hash, nonce string) bool {
+	now := time.Now()
+	key := hash + "/" + nonce
+
+	if ss := shared(); ss != nil {
+		fresh, err := ss.ClaimState("nonce/"+key, now.Add(nonceWindow))
+		if err != nil {
+			// counting a beacon twice beats losing it
+			log.Print("claiming a nonce: ", err)
+			return true
+		}
+		return fresh
+	}
+
+	nonces.Lock()
+	defer nonces.Unlock()
+
+	expires, found := nonces.seen[key]
+	if found && now.Before(expires) {
+		return false
+	}
+
+	// every nonce is kept for the same window, so they expire in the
+	//	order they were used
+	for len(nonces.order) > 0 && (len(nonces.seen) >= maxNonces || !now.Before(nonces.order[0].expires)) {
+		oldest := nonces.order[0]
+		nonces.order = nonces.order[1:]
+		// it may have been used again since, under a later entry
+		if nonces.seen[oldest.key].Equal(oldest.expires) {
+			delete(nonces.seen, oldest.key)
+		}
+	}
+
+	nonces.seen[key] = now.Add(nonceWindow)
+	nonces.order = append(nonces.order, usedNonce{key, now.Add(nonceWindow)})
+	return true
+}
+
+// beaconURL is the /collect/ URL to embed for a link, with a fresh nonce
+// when they're turned on
+func beaconURL(hash string) string {
+	u := appPath("/collect/" + hash)
+	if nonceWindow > 0 {
+		nonce, err := randomCode(16)
+		if err == nil {
+			u += "?nonce=" + nonce
+		}
+	}
+	return u
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func resetNonces(t *testing.T, window time.Duration) {
+	old := nonceWindow
+	nonceWindow = window
+	nonces.Lock()
+	nonces.seen, nonces.order = map[string]time.Time{}, nil
+	nonces.Unlock()
+	t.Cleanup(func() { nonceWindow = old })
+}
+
+func TestConsumeNonce(t *testing.T) {
+	resetNonces(t, time.Hour)
+	if !consumeNonce("abc1234", "n1") {
+		t.Fatal("a new nonce wasn't fresh")
+	}
+	if consumeNonce("abc1234", "n1") {
+		t.Error("a nonce was fresh twice")
+	}
+	if !consumeNonce("def5678", "n1") {
+		t.Error("a nonce used on one link wasn't fresh on another")
+	}
+
+	resetNonces(t, time.Millisecond)
+	consumeNonce("abc1234", "n1")
+	time.Sleep(5 * time.Millisecond)
+	if !consumeNonce("abc1234", "n1") {
+		t.Error("a nonce wasn't fresh again after its window")
+	}
+	if consumeNonce("abc1234", "n1") {
+		t.Error("a nonce reused after its window was fresh twice")
+	}
+}
+
+// a flood of nonces pushes out the oldest rather than shutting out new
+// beacons
+func TestConsumeNonceFull(t *testing.T) {
+	resetNonces(t, time.Hour)
+	for i := 0; i < maxNonces; i++ {
+		consumeNonce("abc1234", strconv.Itoa(i))
+	}
+	if !consumeNonce("abc1234", "new") {
+		t.Error("a new nonce was turned away once the map was full")
+	}
+	if consumeNonce("abc1234", "new") {
+		t.Error("a nonce was fresh twice once the map was full")
+	}
+	if consumeNonce("abc1234", strconv.Itoa(maxNonces-1)) {
+		t.Error("the newest nonces were forgotten")
+	}
+	if !consumeNonce("abc1234", "0") {
+		t.Error("the oldest nonce was kept past the limit")
+	}
+
+	nonces.Lock()
+	defer nonces.Unlock()
+	if len(nonces.seen) > maxNonces || len(nonces.order) > maxNonces+1 {
+		t.Errorf("remembering %d nonces in %d entries, limit %d", len(nonces.seen), len(nonces.order), maxNonces)
+	}
+}
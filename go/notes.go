@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A title and notes help people recognize a link months later, when a hash
+// and a long URL don't say much. They're only ever shown to whoever can
+// see the link, never to visitors.
+const (
+	maxTitle = 200
+	maxNotes = 4000
+)
+
+// cleanTitle trims a title and checks it fits on one line
+func cleanTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if utf8.RuneCountInString(title) > maxTitle {
+		return "", errors.New("titles can be at most 200 characters")
+	}
+	if strings.IndexFunc(title, unicode.IsControl) >= 0 {
+		return "", errors.New("titles have to fit on one line")
+	}
+	return title, nil
+}
+
+// cleanNotes trims notes and checks they aren't too long
+func cleanNotes(notes string) (string, error) {
+	notes = strings.TrimSpace(strings.ReplaceAll(notes, "\r\n", "\n"))
+	if utf8.RuneCountInString(notes) > maxNotes {
+		return "", errors.New("notes can be at most 4000 characters")
+	}
+	return notes, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"sync"
+)
+
+// A workerPool runs outbound work (webhooks, notifications, hit sinks) on a
+// fixed number of goroutines so a burst of clicks can't start an unbounded
+// number of them. When the queue is full the oldest waiting job is dropped.
+type workerPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []func()
+	max    int
+	closed bool
+	wg     sync.WaitGroup
+}
+
+var (
+	outboundDropped = expvar.NewInt("outbound_dropped")
+
+	// outbound is started in main; everything that talks to other servers
+	// as a side effect of a hit should go through it
+	outbound *workerPool
+)
+
+func newWorkerPool(workers, queueLen int) *workerPool {
+	p := &workerPool{max: queueLen}
+	p.cond = sync.NewCond(&p.mu)
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	expvar.Publish("outbound_queue_depth", expvar.Func(func() any {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return len(p.queue)
+	}))
+	return p
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.queue) == 0 {
+			// closed and drained
+			p.mu.Unlock()
+			return
+		}
+		job := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+
+		job()
+	}
+}
+
+// submit queues a job, dropping the oldest queued one if the queue is full.
+// Jobs submitted after close are dropped.
+func (p *workerPool) submit(job func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		outboundDropped.Add(1)
+		return
+	}
+	if len(p.queue) >= p.max {
+		p.queue = p.queue[1:]
+		outboundDropped.Add(1)
+	}
+	p.queue = append(p.queue, job)
+	p.cond.Signal()
+}
+
+// close stops accepting jobs and waits for the queued ones to finish, or for
+// ctx to be done, whichever comes first
+func (p *workerPool) close(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
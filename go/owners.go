@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// With logins turned on each link belongs to whoever created it, and people
+// only see and change their own links. Admins see everyone's: the
+// -admin-password login, which logs in as "admin", and anyone listed in
+// -admins. Links from before owners were recorded have none, so only
+// admins see them.
+var admins = map[string]bool{}
+
+// parseAdmins reads -admins, a comma separated list of user names
+func parseAdmins(s string) map[string]bool {
+	m := map[string]bool{}
+	for _, user := range strings.Split(s, ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			m[user] = true
+		}
+	}
+	return m
+}
+
+// currentUser returns who's making r and whether they're an admin. It's
+// only called behind requireLogin or requireAPIToken, so a request without
+// a session got in with an API token, API key or JWT, which only admins
+// hand out; those act as an admin.
+func currentUser(r *http.Request) (string, bool) {
+	if !loginEnabled() {
+		return "", true
+	}
+	user, ok := sessionUser(r)
+	if !ok {
+		return "", true
+	}
+	return user, user == "admin" || admins[user]
+}
+
+// canSee reports whether whoever's making r can see and change l
+func canSee(r *http.Request, l *Link) bool {
+	user, admin := currentUser(r)
+	return admin || l.Owner == user
+}
+
+// ownLink wraps a handler for one link so that other people's links look
+// like they don't exist
+func ownLink(fn func(http.ResponseWriter, *http.Request, string)) func(http.ResponseWriter, *http.Request, string) {
+	return func(w http.ResponseWriter, r *http.Request, m string) {
+		if _, admin := currentUser(r); !admin {
+			// a link that can't be loaded is left for fn to report
+			l, err := store.LoadLink(m)
+			if err == nil && !canSee(r, l) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		fn(w, r, m)
+	}
+}
+
+// adminOnly keeps handlers that work on every link at once, like exports,
+// to admins
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, admin := currentUser(r); !admin {
+			apiError(w, http.StatusForbidden, "only admins can do that")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/url"
+)
+
+// withQuery adds the query parameters a click came with to destination,
+// for links with PassQuery. Parameters the destination already has keep
+// their value there, so visitors can't change what the link was set up
+// with, and -source-param is ours rather than the destination's.
+func withQuery(destination string, q url.Values) string {
+	if len(q) == 0 {
+		return destination
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+	existing := u.Query()
+	extra := url.Values{}
+	for key, values := range q {
+		if existing.Has(key) || (sourceParam != "" && key == sourceParam) {
+			continue
+		}
+		extra[key] = values
+	}
+	if len(extra) == 0 {
+		return destination
+	}
+	// appended rather than re-encoded, so the destination's own query
+	//	string stays exactly as it was
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += extra.Encode()
+	return u.String()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPassphrase is what's stored in a link's PasswordHash
+func hashPassphrase(passphrase string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(passphrase), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// unlocked reports whether a click on a password-protected link may go
+// through. If it can't, the passphrase form has already been written to w.
+func unlocked(w http.ResponseWriter, r *http.Request, l *Link) bool {
+	if l.PasswordHash == "" {
+		return true
+	}
+
+	form := struct{ Wrong bool }{}
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		err := bcrypt.CompareHashAndPassword([]byte(l.PasswordHash), []byte(r.PostFormValue("passphrase")))
+		if err == nil {
+			return true
+		}
+		// wrong guesses count as misses, so -max-misses also limits how
+		//	fast anyone can guess
+		missedLookup(r)
+		form.Wrong = true
+		status = http.StatusForbidden
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	templates.ExecuteTemplate(w, "password.html", form)
+	return false
+}
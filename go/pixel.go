@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// transparentGIF is a 1x1 transparent GIF, the smallest image every mail
+// client will load
+var transparentGIF = []byte{
+	'G', 'I', 'F', '8', '9', 'a', 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0xff, 0xff, 0xff,
+	'!', 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00,
+	',', 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00,
+	0x02, 0x02, 0x44, 0x01, 0x00,
+	';',
+}
+
+// pixelHandler serves /pixel/<hash>: a tracking pixel for putting in
+// emails, which records a hit every time it's loaded, such as when the
+// email is opened. The image is served whatever happens, even for links
+// that don't exist or have stopped working, so a mail client never shows a
+// broken image; only live links record anything.
+func pixelHandler(w http.ResponseWriter, r *http.Request, m string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "pixels are loaded with GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	l, err := store.LoadLink(m)
+	if err == nil && !l.lapsed() && !l.Expired() && !l.Disabled && !l.Archived && r.Method == http.MethodGet {
+		h := newHit(r)
+		h.Pixel = true
+		if l.records("visitor") {
+			h.Visitor, _ = visitorID(w, r)
+		}
+		err = recordHit(l, h)
+		if err != nil {
+			log.Printf("recording a pixel hit on %s: %v", m, err)
+		} else {
+			pixelsTotal.Add(1)
+		}
+	}
+
+	// every open should reach us, not a cached copy
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Content-Length", strconv.Itoa(len(transparentGIF)))
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	w.Write(transparentGIF)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// notPublicNets are ranges that aren't reachable on the internet, on top
+// of the loopback, private and link-local ones net.IP knows about
+var notPublicNets = []*net.IPNet{
+	mustCIDR("0.0.0.0/8"),
+	mustCIDR("100.64.0.0/10"), // carrier-grade NAT
+	mustCIDR("192.0.0.0/24"),
+	mustCIDR("198.18.0.0/15"), // benchmarking
+	mustCIDR("240.0.0.0/4"),
+	mustCIDR("64:ff9b::/96"), // NAT64, which can reach any IPv4 address
+}
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// publicIP reports whether ip is an address on the public internet, as
+// opposed to this machine or a private network
+func publicIP(ip net.IP) bool {
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range notPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+var errNotPublic = errors.New("only addresses on the public internet can be reached")
+
+// publicOnly is a net.Dialer Control function that refuses to connect to
+// anything but a public address. It sees the address after DNS, so a
+// hostname that resolves somewhere private, or a redirect to one, can't
+// get around it.
+func publicOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicIP(net.ParseIP(host)) {
+		return errNotPublic
+	}
+	return nil
+}
+
+// newPublicClient makes a client for requests whose URL came from a user,
+// which mustn't be able to reach us or the network we're on. It ignores
+// proxy settings, since a proxy would do the dialing for it.
+func newPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: publicOnly}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     time.Minute,
+		},
+	}
+}
+
+// checkPublicHost returns an error if host is, or resolves to, an address
+// that isn't public. A host that can't be looked up right now passes, since
+// publicOnly still stops it when it's dialed.
+func checkPublicHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !publicIP(ip) {
+			return errNotPublic
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return errNotPublic
+		}
+	}
+	return nil
+}
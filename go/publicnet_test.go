@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+	for _, tt := range tests {
+		if got := publicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestPublicClientRefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	_, err := newPublicClient(time.Second).Get(server.URL)
+	if !errors.Is(err, errNotPublic) {
+		t.Errorf("GET %s = %v, want errNotPublic", server.URL, err)
+	}
+	if warning := redirectWarning(context.Background(), server.URL); warning != "" {
+		t.Errorf("redirectWarning reached a loopback server: %s", warning)
+	}
+
+	localhost := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	for _, host := range []string{"127.0.0.1", "localhost", "10.0.0.1", "[::1]"} {
+		if err := checkPublicHost(context.Background(), strings.Trim(host, "[]")); err == nil {
+			t.Errorf("checkPublicHost(%s) passed", host)
+		}
+	}
+	if warning := redirectWarning(context.Background(), localhost); warning != "" {
+		t.Errorf("redirectWarning reached %s: %s", localhost, warning)
+	}
+}
+
+func TestValidateBodyLimit(t *testing.T) {
+	body := `{"destination": "https://example.com/` + strings.Repeat("a", 1<<17) + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/validate", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	validateHandler(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("a %d byte body got %d, want 400", len(body), w.Code)
+	}
+}
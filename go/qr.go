@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// qrHandler serves GET /qr/<hash>, a QR code for the link's short URL. It's
+// a PNG unless ?format=svg is given; ?scale= sets how many pixels wide each
+// module of a PNG is.
+func qrHandler(w http.ResponseWriter, r *http.Request, m string) {
+	_, err := store.LoadLink(m)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// M survives a little damage, which matters for printed codes
+	code, err := qr.Encode(shareableURL(r, m), qr.M)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	switch r.URL.Query().Get("format") {
+	case "", "png":
+		if scale, err := strconv.Atoi(r.URL.Query().Get("scale")); err == nil && scale >= 1 && scale <= 32 {
+			code.Scale = scale
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(code.PNG())
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(qrSVG(code)))
+	default:
+		http.Error(w, "format must be png or svg", http.StatusBadRequest)
+	}
+}
+
+// qrSVG draws a code one module per unit, with the four module quiet zone
+// scanners expect around it
+func qrSVG(code *qr.Code) string {
+	const quiet = 4
+	size := code.Size + 2*quiet
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(&sb, `<rect width="%d" height="%d" fill="#fff"/><path d="`, size, size)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&sb, "M%d %dh1v1h-1z", x+quiet, y+quiet)
+			}
+		}
+	}
+	sb.WriteString(`"/></svg>`)
+	return sb.String()
+}
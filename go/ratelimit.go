@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A rateLimiter gives each client IP a token bucket: it holds up to burst
+// requests and refills at rate per second. A nil limiter allows everything.
+type rateLimiter struct {
+	name  string // for the error message
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// saveLimit, collectLimit and goLimit are set from -rate-save, -rate-collect
+// and -rate-go
+var saveLimit, collectLimit, goLimit *rateLimiter
+
+// parseRate reads a limit like "30/1m": 30 requests a minute, all of which
+// can come at once. An empty string means no limit.
+func parseRate(name, s string) (*rateLimiter, error) {
+	if s == "" {
+		return nil, nil
+	}
+	count, per, found := strings.Cut(s, "/")
+	n, err := strconv.Atoi(count)
+	if !found || err != nil || n <= 0 {
+		return nil, fmt.Errorf("rate %q should look like 30/1m", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("rate %q should look like 30/1m", s)
+	}
+	return &rateLimiter{
+		name:    name,
+		rate:    float64(n) / d.Seconds(),
+		burst:   float64(n),
+		buckets: map[string]*bucket{},
+	}, nil
+}
+
+// allow takes a token from ip's bucket, or says how long until there is one
+func (l *rateLimiter) allow(ip string) (bool, time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.buckets[ip]
+	if b == nil {
+		// buckets that have filled back up are the same as no bucket, so
+		//	they're pruned when the map gets big
+		if len(l.buckets) >= 10000 {
+			for ip, b := range l.buckets {
+				if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+					delete(l.buckets, ip)
+				}
+			}
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimited answers 429 for clients over l's limit before calling handler
+func rateLimited(l *rateLimiter, handler http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, retry := l.allow(clientIP(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			http.Error(w, "too many "+l.name+" requests; slow down", http.StatusTooManyRequests)
+			return
+		}
+		handler(w, r)
+	}
+}
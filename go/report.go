@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// sparkDays is how many days the terminal report's sparkline covers
+const sparkDays = 30
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws one bar per day for the last sparkDays days, ending today
+func sparkline(daily []DayCount, now time.Time) string {
+	counts := map[string]int{}
+	max := 0
+	for _, d := range daily {
+		counts[d.Day] = d.Count
+		if d.Count > max {
+			max = d.Count
+		}
+	}
+
+	var sb strings.Builder
+	for i := sparkDays - 1; i >= 0; i-- {
+		count := counts[now.AddDate(0, 0, -i).Format("2006-01-02")]
+		if max == 0 {
+			sb.WriteRune(sparkBars[0])
+			continue
+		}
+		sb.WriteRune(sparkBars[count*(len(sparkBars)-1)/max])
+	}
+	return sb.String()
+}
+
+type linkReport struct {
+	Hash        string      `json:"hash"`
+	Destination string      `json:"destination"`
+	Total       int         `json:"total"`
+	LastHit     *time.Time  `json:"last_hit,omitempty"`
+	Daily       []DayCount  `json:"daily"`
+	Breakdowns  []Breakdown `json:"breakdowns"`
+}
+
+// printReport writes a link's stats for the -report flag, using the same
+// summary as the analytics page
+func printReport(w io.Writer, hash string, asJSON bool) error {
+	if !validHash.MatchString(hash) {
+		return fmt.Errorf("invalid hash %q", hash)
+	}
+	l, err := store.LoadLink(hash)
+	if err != nil {
+		return err
+	}
+	sum, err := summarize(hash)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		rep := linkReport{Hash: hash, Destination: l.Destination, Total: sum.Total, Daily: sum.Daily, Breakdowns: sum.Breakdowns}
+		if !sum.LastHit.IsZero() {
+			rep.LastHit = &sum.LastHit
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+
+	fmt.Fprintf(w, "%s\n  -> %s\n\n", hash, l.Destination)
+	fmt.Fprintf(w, "clicks:     %d\n", sum.Total)
+	if !sum.LastHit.IsZero() {
+		fmt.Fprintf(w, "last click: %s\n", sum.LastHit.Format("2006-01-02 15:04"))
+	}
+	fmt.Fprintf(w, "last %d days: %s\n", sparkDays, sparkline(sum.Daily, time.Now()))
+
+	for _, b := range sum.Breakdowns {
+		fmt.Fprintf(w, "\n%s:\n", b.Name)
+		if !b.Available {
+			fmt.Fprintf(w, "  unavailable (%s)\n", b.Reason)
+			continue
+		}
+		for i, row := range b.Rows {
+			if i == 5 {
+				fmt.Fprintf(w, "  ... and %d more\n", len(b.Rows)-5)
+				break
+			}
+			fmt.Fprintf(w, "  %6d  %s\n", row.Count, row.Key)
+		}
+	}
+	return nil
+}
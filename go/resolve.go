@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// A resolution is what /api/resolve says about a link: where it goes,
+// without recording a click. It has everything that decides where a click
+// ends up, so the ETag made from it changes whenever any of that does.
+type resolution struct {
+	Hash        string                 `json:"hash"`
+	Destination string                 `json:"destination"`
+	Mirrors     []string               `json:"mirrors,omitempty"`
+	Variants    []Variant              `json:"variants,omitempty"`
+	Rules       []Rule                 `json:"rules,omitempty"`
+	GeoRules    []GeoRule              `json:"geo_rules,omitempty"`
+	Schedule    []ScheduledDestination `json:"schedule,omitempty"`
+	Timezone    string                 `json:"timezone,omitempty"` // the schedule's, which may be the server's
+	Ruleset     string                 `json:"ruleset,omitempty"`
+
+	// RulesetRules is the ruleset as it's loaded now, since editing the
+	//	rulesets file changes where the link goes
+	RulesetRules *Ruleset `json:"ruleset_rules,omitempty"`
+
+	RedirectStatus int        `json:"redirect_status"`
+	Interstitial   bool       `json:"interstitial,omitempty"`
+	PassQuery      bool       `json:"pass_query,omitempty"`
+	Passphrase     bool       `json:"passphrase,omitempty"`
+	MaxClicks      int        `json:"max_clicks,omitempty"`
+	Expires        *time.Time `json:"expires,omitempty"`
+	IdleTTL        Duration   `json:"idle_ttl,omitempty"`
+	Expired        bool       `json:"expired,omitempty"`
+	Disabled       bool       `json:"disabled,omitempty"`
+	Archived       bool       `json:"archived,omitempty"`
+	ShortURL       string     `json:"short_url"`
+}
+
+func newResolution(r *http.Request, l *Link) resolution {
+	res := resolution{
+		Hash:           l.Hash,
+		Destination:    l.Destination,
+		Mirrors:        l.Mirrors,
+		Variants:       l.Variants,
+		Rules:          l.Rules,
+		GeoRules:       l.GeoRules,
+		Schedule:       l.Schedule,
+		Ruleset:        l.Ruleset,
+		RulesetRules:   rulesets[l.Ruleset],
+		RedirectStatus: l.redirectStatus(),
+		Interstitial:   l.Interstitial || interstitialAll,
+		PassQuery:      l.PassQuery,
+		Passphrase:     l.PasswordHash != "",
+		MaxClicks:      l.MaxClicks,
+		IdleTTL:        l.IdleTTL,
+		Expired:        l.Expired() || l.lapsed(),
+		Disabled:       l.Disabled,
+		Archived:       l.Archived,
+		ShortURL:       shareableURL(r, l.Hash),
+	}
+	if len(l.Schedule) > 0 {
+		res.Timezone = l.location().String()
+	}
+	if !l.Expires.IsZero() {
+		res.Expires = &l.Expires
+	}
+	return res
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// apiResolveHandler serves GET /api/resolve/<hash>. The body only changes
+// when the link or its ruleset is edited, or it expires, so it carries a strong ETag made from it and
+// answers a matching If-None-Match with 304.
+func apiResolveHandler(w http.ResponseWriter, r *http.Request, m string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		apiError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+
+	l, err := store.LoadLink(m)
+	if os.IsNotExist(err) {
+		apiError(w, http.StatusNotFound, "no such link")
+		return
+	} else if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	body, err := json.Marshal(newResolution(r, l))
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// resolveLink saves l and asks /api/resolve about it
+func resolveLink(t *testing.T, l *Link, ifNoneMatch string) *httptest.ResponseRecorder {
+	t.Helper()
+	inTempDir(t)
+	err := store.SaveLink(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/resolve/"+l.Hash, nil)
+	if ifNoneMatch != "" {
+		r.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	w := httptest.NewRecorder()
+	apiResolveHandler(w, r, l.Hash)
+	return w
+}
+
+func baseResolveLink() *Link {
+	return &Link{Hash: "abc1234", Destination: "https://example.com/"}
+}
+
+func TestResolveETagChanges(t *testing.T) {
+	oldRulesets := rulesets
+	rulesets = map[string]*Ruleset{
+		"apps":  {Rules: []Rule{{Device: "ios", Destination: "https://apps.apple.com/"}}},
+		"apps2": {Rules: []Rule{{Device: "android", Destination: "https://play.google.com/"}}},
+	}
+	t.Cleanup(func() { rulesets = oldRulesets })
+
+	base := resolveLink(t, baseResolveLink(), "").Header().Get("ETag")
+	if base == "" {
+		t.Fatal("no ETag")
+	}
+	if again := resolveLink(t, baseResolveLink(), "").Header().Get("ETag"); again != base {
+		t.Fatalf("ETag changed from %s to %s without the link changing", base, again)
+	}
+
+	tests := []struct {
+		name   string
+		change func(l *Link)
+	}{
+		{"destination", func(l *Link) { l.Destination = "https://example.com/other" }},
+		{"mirrors", func(l *Link) { l.Mirrors = []string{"https://mirror.example.com/"} }},
+		{"variants", func(l *Link) { l.Variants = []Variant{{"https://example.com/a", 50}, {"https://example.com/b", 50}} }},
+		{"rules", func(l *Link) { l.Rules = []Rule{{Device: "ios", Destination: "https://apps.apple.com/"}} }},
+		{"geo rules", func(l *Link) { l.GeoRules = []GeoRule{{Countries: []string{"DE"}, Destination: "https://example.de/"}} }},
+		{"schedule", func(l *Link) {
+			l.Schedule = []ScheduledDestination{{From: "2030-01-01T00:00", Destination: "https://example.com/later"}}
+		}},
+		{"ruleset", func(l *Link) { l.Ruleset = "apps" }},
+		{"redirect status", func(l *Link) { l.RedirectStatus = http.StatusMovedPermanently }},
+		{"interstitial", func(l *Link) { l.Interstitial = true }},
+		{"pass query", func(l *Link) { l.PassQuery = true }},
+		{"passphrase", func(l *Link) { l.PasswordHash = "$2a$10$abcdefghijklmnopqrstuv" }},
+		{"max clicks", func(l *Link) { l.MaxClicks = 10 }},
+		{"expires", func(l *Link) { l.Expires = time.Now().Add(time.Hour).Truncate(time.Second) }},
+		{"idle ttl", func(l *Link) { l.IdleTTL = Duration(time.Hour) }},
+		{"disabled", func(l *Link) { l.Disabled = true }},
+		{"archived", func(l *Link) { l.Archived = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := baseResolveLink()
+			tt.change(l)
+			w := resolveLink(t, l, base)
+			if w.Code != http.StatusOK {
+				t.Errorf("changing the %s still answered %d to the old ETag", tt.name, w.Code)
+			}
+			if etag := w.Header().Get("ETag"); etag == base {
+				t.Errorf("changing the %s kept the ETag %s", tt.name, etag)
+			}
+		})
+	}
+}
+
+// a link's ETag follows things kept outside it that change where it goes
+func TestResolveETagFollowsSettings(t *testing.T) {
+	oldRulesets, oldZone := rulesets, scheduleTimezone
+	t.Cleanup(func() { rulesets, scheduleTimezone = oldRulesets, oldZone })
+
+	rulesetLink := func() *Link {
+		l := baseResolveLink()
+		l.Ruleset = "apps"
+		return l
+	}
+	rulesets = map[string]*Ruleset{"apps": {Rules: []Rule{{Device: "ios", Destination: "https://apps.apple.com/"}}}}
+	before := resolveLink(t, rulesetLink(), "").Header().Get("ETag")
+	rulesets = map[string]*Ruleset{"apps": {Rules: []Rule{{Device: "ios", Destination: "https://apps.apple.com/new"}}}}
+	if after := resolveLink(t, rulesetLink(), before).Header().Get("ETag"); after == before {
+		t.Error("editing the link's ruleset kept its ETag")
+	}
+
+	scheduledLink := func(zone string) *Link {
+		l := baseResolveLink()
+		l.Schedule = []ScheduledDestination{{From: "2030-01-01T00:00", Destination: "https://example.com/later"}}
+		l.Timezone = zone
+		return l
+	}
+	scheduleTimezone = time.UTC
+	before = resolveLink(t, scheduledLink(""), "").Header().Get("ETag")
+	if after := resolveLink(t, scheduledLink("Europe/Berlin"), before).Header().Get("ETag"); after == before {
+		t.Error("changing the link's time zone kept its ETag")
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	scheduleTimezone = berlin
+	if after := resolveLink(t, scheduledLink(""), before).Header().Get("ETag"); after == before {
+		t.Error("changing -timezone kept the ETag of a link that uses it")
+	}
+
+	expiring := baseResolveLink()
+	expiring.Expires = time.Now().Add(time.Hour)
+	before = resolveLink(t, expiring, "").Header().Get("ETag")
+	expiring.Expires = time.Now().Add(-time.Hour)
+	if after := resolveLink(t, expiring, before).Header().Get("ETag"); after == before {
+		t.Error("expiring kept the ETag")
+	}
+}
+
+func TestResolveNotModified(t *testing.T) {
+	etag := resolveLink(t, baseResolveLink(), "").Header().Get("ETag")
+	tests := []struct {
+		ifNoneMatch string
+		want        int
+	}{
+		{etag, http.StatusNotModified},
+		{`"other", ` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"other"`, http.StatusOK},
+		{"W/" + etag, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := resolveLink(t, baseResolveLink(), tt.ifNoneMatch)
+		if w.Code != tt.want {
+			t.Errorf("If-None-Match: %s answered %d, want %d", tt.ifNoneMatch, w.Code, tt.want)
+		}
+		if w.Code == http.StatusNotModified && w.Body.Len() > 0 {
+			t.Errorf("If-None-Match: %s answered 304 with a body", tt.ifNoneMatch)
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match: %s answered with ETag %s, want %s", tt.ifNoneMatch, w.Header().Get("ETag"), etag)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var (
+	// afterCreate is where saveHandler sends people once a link is saved,
+	// with "{hash}" replaced by the link's hash. Paths are under the base
+	// path; full URLs are used as they are.
+	afterCreate = "/analytics/{hash}"
+
+	// returnToHosts are the hosts a return_to parameter may send people
+	// to; paths on this server are always allowed
+	returnToHosts = map[string]bool{}
+)
+
+var errReturnTo = errors.New("return_to must be a path on this server or a URL on an allowed host")
+
+// createdRedirect works out where to send someone who just saved hash.
+// returnTo comes from the request, so it's checked to keep saveHandler from
+// being an open redirect.
+func createdRedirect(returnTo, hash string) (string, error) {
+	target := afterCreate
+	if returnTo != "" {
+		target = returnTo
+	}
+	target = strings.ReplaceAll(target, "{hash}", hash)
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", errReturnTo
+	}
+
+	if u.Scheme == "" && u.Host == "" {
+		// browsers treat "//host" and "/\host" as other hosts
+		if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.Contains(target, "\\") {
+			return "", errReturnTo
+		}
+		return appPath(target), nil
+	}
+
+	if returnTo != "" {
+		allowed := (u.Scheme == "https" || u.Scheme == "http") && u.User == nil && returnToHosts[strings.ToLower(u.Host)]
+		if !allowed {
+			return "", errReturnTo
+		}
+	}
+	return target, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func randomCode(length int) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(base62))))
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(base62[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// codeInUse reports whether a code belongs to a link or a live alias. A
+// store that can't tell is an error rather than a yes, so nothing retries
+// forever while it's down.
+func codeInUse(code string) (bool, error) {
+	_, err := store.LoadLink(code)
+	if err == nil {
+		return true, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	_, err = findAlias(code)
+	if err == nil {
+		return true, nil
+	} else if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// newCode returns a random 8 character code that nothing is using yet
+func newCode() (string, error) {
+	return randomIDs{8}.Next("")
+}
+
+// An alias keeps an old code working for a while after its link has been
+// given a new one. Aliases are kept in the store's shared state when it has
+// one, so every instance knows them, and otherwise in "<old code>.alias"
+// files.
+type alias struct {
+	To    string    `json:"to"`
+	Until time.Time `json:"until"`
+}
+
+// loadAlias returns code's alias if it has a live one, treating one that
+// can't be read as none
+func loadAlias(code string) (*alias, bool) {
+	a, err := findAlias(code)
+	return a, err == nil
+}
+
+// findAlias returns code's live alias, or an error for which os.IsNotExist
+// is true if it hasn't one
+func findAlias(code string) (*alias, error) {
+	var contents []byte
+	var err error
+	ss := shared()
+	if ss != nil {
+		contents, err = ss.GetState("alias/" + code)
+	} else {
+		contents, err = os.ReadFile(code + ".alias")
+	}
+	if err != nil {
+		return nil, err
+	}
+	a := &alias{}
+	err = json.Unmarshal(contents, a)
+	if err != nil {
+		return nil, fmt.Errorf("alias %s: %w", code, err)
+	}
+	if time.Now().After(a.Until) {
+		// shared ones expire by themselves
+		if ss == nil {
+			os.Remove(code + ".alias")
+		}
+		return nil, &fs.PathError{Op: "open", Path: code, Err: fs.ErrNotExist}
+	}
+	return a, nil
+}
+
+func saveAlias(code string, a alias) error {
+	contents, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if ss := shared(); ss != nil {
+		return ss.PutState("alias/"+code, contents, a.Until)
+	}
+	return os.WriteFile(code+".alias", contents, 0600)
+}
+
+// rotateLink moves a link, along with its metadata and hits, to a new code.
+// If grace is positive the old code keeps redirecting to the new one for
+// that long.
+func rotateLink(l *Link, grace time.Duration) (string, error) {
+	// if another link grabbed the same code in the meantime we just try
+	//	again, up to the same limit as creating a link
+	var code string
+	for i := 0; ; i++ {
+		if i == maxCodeAttempts {
+			return "", errNoFreeCode
+		}
+		var err error
+		code, err = newCode()
+		if err != nil {
+			return "", err
+		}
+		err = store.RenameLink(l.Hash, code)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+	invalidateSummary(l.Hash)
+
+	if grace > 0 {
+		err := saveAlias(l.Hash, alias{To: code, Until: time.Now().Add(grace)})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return code, nil
+}
+
+// rotateHandler handles POST /rotate/<hash>. The optional grace form value
+// is how long the old code should keep redirecting, as a Go duration like
+// "720h".
+func rotateHandler(w http.ResponseWriter, r *http.Request, m string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "rotating a link needs a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var grace time.Duration
+	if g := r.FormValue("grace"); g != "" {
+		var err error
+		grace, err = time.ParseDuration(g)
+		if err != nil || grace < 0 {
+			http.Error(w, "grace must be a duration like 720h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	l, err := store.LoadLink(m)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	code, err := rotateLink(l, grace)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("audit: %s rotated link %s to %s (old code kept for %s)", r.RemoteAddr, m, code, grace)
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, map[string]string{"hash": code, "short_url": shareableURL(r, code)})
+		return
+	}
+	http.Redirect(w, r, appPath("/analytics/"+code), http.StatusSeeOther)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// racingStore loses every race for a new code, as if other instances took
+// each one just before it
+type racingStore struct {
+	Store
+	renames int
+}
+
+func (s *racingStore) RenameLink(hash, code string) error {
+	s.renames++
+	return &fs.PathError{Op: "rename", Path: code, Err: fs.ErrExist}
+}
+
+func TestRotateGivesUp(t *testing.T) {
+	inTempDir(t)
+	l := saveTestLink(t, "abc1234", "https://example.com/")
+	racing := &racingStore{Store: store}
+	store = racing
+
+	_, err := rotateLink(l, 0)
+	if err != errNoFreeCode {
+		t.Errorf("rotateLink() = %v, want errNoFreeCode", err)
+	}
+	if racing.renames != maxCodeAttempts {
+		t.Errorf("tried %d codes, want %d", racing.renames, maxCodeAttempts)
+	}
+}
+
+func TestRotatedCodeRedirectsTemporarily(t *testing.T) {
+	inTempDir(t)
+	l := saveTestLink(t, "abc1234", "https://example.com/")
+	code, err := rotateLink(l, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	goHandler(w, httptest.NewRequest(http.MethodGet, "/go/abc1234", nil), "abc1234")
+	if w.Code != http.StatusFound {
+		t.Errorf("the old code answered %d, want 302", w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "http://example.com/go/"+code {
+		t.Errorf("the old code went to %q, want the new one %s", loc, code)
+	}
+}
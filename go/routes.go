@@ -0,0 +1,61 @@
+package main
+
+import "net/http"
+
+// routes are everything registered with route and apiRoute, in order
+var routes []routeInfo
+
+// mux serves the routes. It isn't http.DefaultServeMux, which packages like
+// expvar register their own handlers on, such as /debug/vars with our
+// command line and its secrets.
+var mux = http.NewServeMux()
+
+type routeInfo struct {
+	Pattern string   `json:"pattern"`
+	Methods []string `json:"methods"`
+	Auth    bool     `json:"auth"` // needs an API token, JWT or login
+
+	// needsAuth works out Auth when the routes are listed, since API keys
+	//	can be issued after the route is registered
+	needsAuth func() bool
+}
+
+func authNever() bool  { return false }
+func authAlways() bool { return true }
+
+// route registers a handler along with the methods it answers, which are
+// only used to describe it
+func route(pattern string, handler http.HandlerFunc, methods ...string) {
+	routes = append(routes, routeInfo{Pattern: pattern, Methods: methods, needsAuth: authNever})
+	mux.HandleFunc(pattern, timed(pattern, handler))
+}
+
+// apiRoute registers a handler behind requireAPIToken, callable from the
+// -cors-origins
+func apiRoute(pattern string, handler http.HandlerFunc, methods ...string) {
+	routes = append(routes, routeInfo{Pattern: pattern, Methods: methods, needsAuth: authConfigured})
+	mux.HandleFunc(pattern, timed(pattern, allowCORS(requireAPIToken(handler), methods...)))
+}
+
+// adminRoute registers a page behind requireLogin
+func adminRoute(pattern string, handler http.HandlerFunc, methods ...string) {
+	routes = append(routes, routeInfo{Pattern: pattern, Methods: methods, needsAuth: loginEnabled})
+	mux.HandleFunc(pattern, timed(pattern, requireLogin(handler)))
+}
+
+// authRoute registers a page that changes existing links behind
+// requireAuth, so it's never open to everyone
+func authRoute(pattern string, handler http.HandlerFunc, methods ...string) {
+	routes = append(routes, routeInfo{Pattern: pattern, Methods: methods, needsAuth: authAlways})
+	mux.HandleFunc(pattern, timed(pattern, requireAuth(handler)))
+}
+
+// routesHandler serves GET /api/routes
+func routesHandler(w http.ResponseWriter, r *http.Request) {
+	list := make([]routeInfo, len(routes))
+	for i, rt := range routes {
+		rt.Auth = rt.needsAuth()
+		list[i] = rt
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"base_path": basePath, "routes": list})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// /api/routes says what auth a route needs as things stand when it's asked,
+// not when the route was registered
+func TestRoutesAuthIsCurrent(t *testing.T) {
+	oldRoutes, oldToken := routes, apiToken
+	routes = []routeInfo{
+		{Pattern: "/public", needsAuth: authNever},
+		{Pattern: "/api/thing", needsAuth: authConfigured},
+		{Pattern: "/edit/", needsAuth: authAlways},
+	}
+	t.Cleanup(func() { routes, apiToken = oldRoutes, oldToken })
+
+	listed := func() map[string]bool {
+		w := httptest.NewRecorder()
+		routesHandler(w, httptest.NewRequest(http.MethodGet, "/api/routes", nil))
+		var body struct {
+			Routes []routeInfo `json:"routes"`
+		}
+		err := json.NewDecoder(w.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth := map[string]bool{}
+		for _, rt := range body.Routes {
+			auth[rt.Pattern] = rt.Auth
+		}
+		return auth
+	}
+
+	apiToken = ""
+	if authConfigured() {
+		t.Skip("some other auth is configured")
+	}
+	want := map[string]bool{"/public": false, "/api/thing": false, "/edit/": true}
+	if got := listed(); !equalAuth(got, want) {
+		t.Errorf("without auth: %v, want %v", got, want)
+	}
+
+	apiToken = "secret"
+	want["/api/thing"] = true
+	if got := listed(); !equalAuth(got, want) {
+		t.Errorf("after setting an API token: %v, want %v", got, want)
+	}
+}
+
+func equalAuth(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A Rule sends matching visitors somewhere other than the link's destination
+type Rule struct {
+	Device      string `json:"device"` // "ios", "android" or "other"
+	Destination string `json:"destination"`
+
+	// Countries limits a ruleset's rule to visitors from these countries,
+	//	as ISO codes or "EU" like in a GeoRule. A rule with countries can
+	//	leave out the device to match any. Links' own rules can't have
+	//	them; those use GeoRules.
+	Countries []string `json:"countries,omitempty"`
+}
+
+// matches reports whether a visitor on device from country (which is "" if
+// it's unknown) gets sent to rule's destination
+func (rule Rule) matches(device, country string) bool {
+	if rule.Device != "" && rule.Device != device {
+		return false
+	}
+	return len(rule.Countries) == 0 || countryIn(rule.Countries, country)
+}
+
+// A Variant is one weighted arm of an A/B split
+type Variant struct {
+	Destination string `json:"destination"`
+	Weight      int    `json:"weight"`
+}
+
+// A Ruleset is a named group of rules that many links can share. Rules are
+// checked in order and the first match wins; if nothing matches, a
+// destination is picked from Split, and failing that the link's own
+// destination is used. Rules can match on countries as well as devices, so
+// {"device": "ios", "countries": ["DE"]} only matches iPhones in Germany.
+type Ruleset struct {
+	Rules []Rule    `json:"rules"`
+	Split []Variant `json:"split"`
+}
+
+// rulesets are loaded once at startup from the file given by -rulesets
+var rulesets = map[string]*Ruleset{}
+
+func loadRulesets(filename string) (map[string]*Ruleset, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := map[string]*Ruleset{}
+	err = json.Unmarshal(contents, &sets)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, rs := range sets {
+		if rs == nil {
+			return nil, fmt.Errorf("ruleset %q is empty", name)
+		}
+		for i, rule := range rs.Rules {
+			if !validDevice(rule.Device) && (rule.Device != "" || len(rule.Countries) == 0) {
+				return nil, fmt.Errorf("ruleset %q: unknown device %q", name, rule.Device)
+			}
+			if rule.Destination == "" {
+				return nil, fmt.Errorf("ruleset %q: rule %d has no destination", name, i+1)
+			}
+			if len(rule.Countries) > 0 && geoDB == nil {
+				return nil, fmt.Errorf("ruleset %q: rule %d matches countries, which needs a GeoIP database from -geoip-db", name, i+1)
+			}
+			for j, c := range rule.Countries {
+				c = strings.ToUpper(strings.TrimSpace(c))
+				if !validCountry.MatchString(c) {
+					return nil, fmt.Errorf("ruleset %q: rule %d: %q isn't a two letter country code", name, i+1, c)
+				}
+				rs.Rules[i].Countries[j] = c
+			}
+			// held to the same checks as links' own destinations
+			normalized, _, err := normalizeDestination(rule.Destination)
+			if err != nil {
+				return nil, fmt.Errorf("ruleset %q: rule %d: %v", name, i+1, err)
+			}
+			rs.Rules[i].Destination = normalized
+		}
+		for i, v := range rs.Split {
+			if v.Weight < 0 || v.Destination == "" {
+				return nil, fmt.Errorf("ruleset %q: invalid split variant %q", name, v.Destination)
+			}
+			normalized, _, err := normalizeDestination(v.Destination)
+			if err != nil {
+				return nil, fmt.Errorf("ruleset %q: split variant %d: %v", name, i+1, err)
+			}
+			rs.Split[i].Destination = normalized
+		}
+	}
+	return sets, nil
+}
+
+// rulesetNames is used by create.html to offer the configured rulesets
+func rulesetNames() []string {
+	names := make([]string, 0, len(rulesets))
+	for name := range rulesets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validDevice(device string) bool {
+	return device == "ios" || device == "android" || device == "other"
+}
+
+// validateRules checks a link's own rules and normalizes their destinations
+// in place
+func validateRules(rules []Rule) error {
+	seen := map[string]bool{}
+	for i, rule := range rules {
+		if !validDevice(rule.Device) {
+			return fmt.Errorf("unknown device %q; rules are for ios, android or other", rule.Device)
+		}
+		if len(rule.Countries) > 0 {
+			return fmt.Errorf("rule for %s has countries; send visitors by country with geo_rules", rule.Device)
+		}
+		if seen[rule.Device] {
+			return fmt.Errorf("there's more than one rule for %s", rule.Device)
+		}
+		seen[rule.Device] = true
+		normalized, _, err := normalizeDestination(rule.Destination)
+		if err != nil {
+			return fmt.Errorf("rule for %s: %v", rule.Device, err)
+		}
+		rules[i].Destination = normalized
+	}
+	return nil
+}
+
+// deviceDestination is where l's own rules send r, if any of them match.
+// They're checked before l's ruleset, so a link can stand on its own
+// without a shared rulesets file.
+func (l *Link) deviceDestination(r *http.Request) (string, bool) {
+	device := deviceOf(r.Header.Get("User-Agent"))
+	for _, rule := range l.Rules {
+		if rule.Device == device {
+			return rule.Destination, true
+		}
+	}
+	return "", false
+}
+
+// RuleFor is where l sends visitors on device, for showing on the analytics
+// page
+func (l *Link) RuleFor(device string) string {
+	for _, rule := range l.Rules {
+		if rule.Device == device {
+			return rule.Destination
+		}
+	}
+	return ""
+}
+
+func deviceOf(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"), strings.Contains(ua, "iPod"):
+		return "ios"
+	case strings.Contains(ua, "Android"):
+		return "android"
+	}
+	return "other"
+}
+
+// resolve picks where a visitor should be sent, falling back to fallback
+// (normally the link's destination) when no rule applies
+func (rs *Ruleset) resolve(r *http.Request, fallback string) string {
+	device := deviceOf(r.Header.Get("User-Agent"))
+	// the GeoIP lookup is only worth doing for rulesets that need it
+	country := ""
+	for _, rule := range rs.Rules {
+		if len(rule.Countries) > 0 {
+			country, _ = locate(clientIP(r))
+			break
+		}
+	}
+	for _, rule := range rs.Rules {
+		if rule.matches(device, country) {
+			return rule.Destination
+		}
+	}
+
+	total := 0
+	for _, v := range rs.Split {
+		total += v.Weight
+	}
+	if total > 0 {
+		n := rand.Intn(total)
+		for _, v := range rs.Split {
+			if n < v.Weight {
+				return v.Destination
+			}
+			n -= v.Weight
+		}
+	}
+
+	return fallback
+}
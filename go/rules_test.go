@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule    Rule
+		device  string
+		country string
+		want    bool
+	}{
+		{Rule{Device: "ios"}, "ios", "", true},
+		{Rule{Device: "ios"}, "android", "DE", false},
+		{Rule{Device: "ios", Countries: []string{"DE"}}, "ios", "DE", true},
+		{Rule{Device: "ios", Countries: []string{"DE"}}, "ios", "US", false},
+		{Rule{Device: "ios", Countries: []string{"DE"}}, "android", "DE", false},
+		{Rule{Device: "ios", Countries: []string{"DE"}}, "ios", "", false},
+		{Rule{Countries: []string{"US", "CA"}}, "other", "CA", true},
+		{Rule{Countries: []string{"EU"}}, "android", "FR", true},
+		{Rule{Countries: []string{"EU"}}, "android", "CH", false},
+		{Rule{Countries: []string{"EU"}}, "android", "", false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matches(tt.device, tt.country); got != tt.want {
+			t.Errorf("%+v matches(%q, %q) = %v, want %v", tt.rule, tt.device, tt.country, got, tt.want)
+		}
+	}
+}
+
+func TestLoadRulesetsCountries(t *testing.T) {
+	if geoDB != nil {
+		t.Skip("a GeoIP database is open")
+	}
+	path := filepath.Join(t.TempDir(), "rulesets.json")
+	write := func(contents string) {
+		err := os.WriteFile(path, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"apps": {"rules": [{"device": "ios", "destination": "https://apps.apple.com/"}]}}`)
+	if _, err := loadRulesets(path); err != nil {
+		t.Errorf("ruleset without countries: %v", err)
+	}
+	write(`{"geo": {"rules": [{"countries": ["DE"], "destination": "https://example.de/"}]}}`)
+	if _, err := loadRulesets(path); err == nil {
+		t.Error("loaded a ruleset that matches countries without a GeoIP database")
+	}
+	write(`{"bad": {"rules": [{"destination": "https://example.com/"}]}}`)
+	if _, err := loadRulesets(path); err == nil {
+		t.Error("loaded a rule with neither a device nor countries")
+	}
+
+	err := validateRules([]Rule{{Device: "ios", Destination: "https://apps.apple.com/", Countries: []string{"DE"}}})
+	if err == nil {
+		t.Error("a link's own rule took countries")
+	}
+}
+
+func TestLoadRulesetsDestinations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rulesets.json")
+	load := func(contents string) (map[string]*Ruleset, error) {
+		err := os.WriteFile(path, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loadRulesets(path)
+	}
+
+	if _, err := load(`{"empty": null}`); err == nil {
+		t.Error("loaded a null ruleset")
+	}
+
+	sets, err := load(`{"apps": {"rules": [{"device": "ios", "destination": "HTTPS://Apps.Apple.COM:443/app"}], "split": [{"destination": "example.com/b", "weight": 1}]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sets["apps"].Rules[0].Destination; got != "https://apps.apple.com/app" {
+		t.Errorf("rule destination = %q, want it normalized", got)
+	}
+	if got := sets["apps"].Split[0].Destination; got != "https://example.com/b" {
+		t.Errorf("split destination = %q, want it normalized", got)
+	}
+
+	if _, err := load(`{"bad": {"rules": [{"device": "ios", "destination": "javascript:alert(1)"}]}}`); err == nil {
+		t.Error("loaded a javascript: destination")
+	}
+
+	oldDenied := deniedHosts
+	deniedHosts = []string{"evil.example"}
+	_, err = load(`{"bad": {"split": [{"destination": "https://evil.example/", "weight": 1}]}}`)
+	deniedHosts = oldDenied
+	if err == nil {
+		t.Error("loaded a destination on a denied host")
+	}
+
+	oldHTTPS := httpsOnly
+	httpsOnly = true
+	_, err = load(`{"bad": {"rules": [{"device": "android", "destination": "http://example.com/"}]}}`)
+	httpsOnly = oldHTTPS
+	if err == nil {
+		t.Error("loaded an http:// destination with https only")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A ScheduledDestination is where a link goes during a window of time, like
+// a product page from its launch on. Its times are wall clock times in the
+// link's time zone, worked out on every redirect, so a window starting at
+// 09:00 starts at 09:00 there whatever daylight saving does in between.
+type ScheduledDestination struct {
+	From        string `json:"from,omitempty"`  // "" for since always
+	Until       string `json:"until,omitempty"` // "" for from then on
+	Destination string `json:"destination"`
+}
+
+// scheduleLayout is how scheduled times are written, the same as a
+// datetime-local input
+const scheduleLayout = "2006-01-02T15:04"
+
+// scheduleTimezone is what scheduled times are in for links that don't set
+// a time zone of their own, from -timezone
+var scheduleTimezone = time.Local
+
+// location is the time zone l's schedule is in
+func (l *Link) location() *time.Location {
+	if l.Timezone != "" {
+		loc, err := time.LoadLocation(l.Timezone)
+		if err == nil {
+			return loc
+		}
+	}
+	return scheduleTimezone
+}
+
+// window returns when s starts and ends in loc, zero for an open end
+func (s ScheduledDestination) window(loc *time.Location) (from, until time.Time, err error) {
+	if s.From != "" {
+		from, err = time.ParseInLocation(scheduleLayout, s.From, loc)
+		if err != nil {
+			return from, until, fmt.Errorf("%q should look like 2006-01-02T15:04", s.From)
+		}
+	}
+	if s.Until != "" {
+		until, err = time.ParseInLocation(scheduleLayout, s.Until, loc)
+		if err != nil {
+			return from, until, fmt.Errorf("%q should look like 2006-01-02T15:04", s.Until)
+		}
+	}
+	return from, until, nil
+}
+
+// scheduledDestination is where l's schedule sends clicks at now, if one
+// of its windows is open. The first open window wins; outside all of them
+// clicks go to the destination.
+func (l *Link) scheduledDestination(now time.Time) (string, bool) {
+	if len(l.Schedule) == 0 {
+		return "", false
+	}
+	loc := l.location()
+	for _, s := range l.Schedule {
+		from, until, err := s.window(loc)
+		if err != nil {
+			continue
+		}
+		if (from.IsZero() || !now.Before(from)) && (until.IsZero() || now.Before(until)) {
+			return s.Destination, true
+		}
+	}
+	return "", false
+}
+
+// validateSchedule checks a link's schedule and time zone and normalizes
+// the schedule's destinations in place
+func validateSchedule(schedule []ScheduledDestination, timezone string) error {
+	loc := scheduleTimezone
+	if timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(timezone)
+		if err != nil {
+			return fmt.Errorf("unknown time zone %q; use a name like Europe/Berlin", timezone)
+		}
+	}
+	for i, s := range schedule {
+		if s.From == "" && s.Until == "" {
+			return fmt.Errorf("scheduled destination %d needs a start, an end or both", i+1)
+		}
+		from, until, err := s.window(loc)
+		if err != nil {
+			return fmt.Errorf("scheduled destination %d: %v", i+1, err)
+		}
+		if !from.IsZero() && !until.IsZero() && !until.After(from) {
+			return fmt.Errorf("scheduled destination %d ends before it starts", i+1)
+		}
+		normalized, _, err := normalizeDestination(s.Destination)
+		if err != nil {
+			return fmt.Errorf("scheduled destination %d: %v", i+1, err)
+		}
+		schedule[i].Destination = normalized
+	}
+	return nil
+}
+
+// parseSchedule reads the create form's schedule, one "<from>..<until>
+// <url>" per line, where either time can be left out
+func parseSchedule(s string) ([]ScheduledDestination, error) {
+	var schedule []ScheduledDestination
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		window, destination, _ := strings.Cut(line, " ")
+		from, until, found := strings.Cut(window, "..")
+		if !found {
+			return nil, errors.New("scheduled destinations go one per line, like: 2026-11-01T09:00.. https://example.com/launched")
+		}
+		schedule = append(schedule, ScheduledDestination{From: from, Until: until, Destination: strings.TrimSpace(destination)})
+	}
+	return schedule, nil
+}
+
+// ScheduleText is l's schedule the way the create form takes it
+func (l *Link) ScheduleText() string {
+	var lines []string
+	for _, s := range l.Schedule {
+		lines = append(lines, s.From+".."+s.Until+" "+s.Destination)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ScheduleList describes l's schedule for the analytics page
+func (l *Link) ScheduleList() []string {
+	var list []string
+	for _, s := range l.Schedule {
+		switch {
+		case s.From == "":
+			list = append(list, "until "+s.Until+" to "+s.Destination)
+		case s.Until == "":
+			list = append(list, "from "+s.From+" to "+s.Destination)
+		default:
+			list = append(list, "from "+s.From+" until "+s.Until+" to "+s.Destination)
+		}
+	}
+	return list
+}
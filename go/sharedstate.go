@@ -0,0 +1,37 @@
+package main
+
+import "time"
+
+// A sharedState keeps the small things every instance has to agree on
+// besides links and hits: rotated codes' aliases, API keys and used
+// nonces. Stores that several instances can share implement it. The file
+// store can't be shared, so with it they're kept in local files and memory
+// as before.
+//
+// Values are kept under names like "alias/<code>", and an expiry, zero for
+// never, after which a value is treated as gone.
+type sharedState interface {
+	// GetState returns the value kept under name, or an error for which
+	// os.IsNotExist is true
+	GetState(name string) ([]byte, error)
+
+	PutState(name string, value []byte, expires time.Time) error
+
+	// DeleteState fails with an error for which os.IsNotExist is true if
+	// there's nothing under name
+	DeleteState(name string) error
+
+	// ListState returns every live value whose name starts with prefix
+	ListState(prefix string) (map[string][]byte, error)
+
+	// ClaimState keeps an empty value under name until expires unless a
+	// live one is already there, reporting whether it did. It's atomic
+	// across instances.
+	ClaimState(name string, expires time.Time) (bool, error)
+}
+
+// shared returns the store's sharedState, or nil if it doesn't have one
+func shared() sharedState {
+	s, _ := unwrapStore(store).(sharedState)
+	return s
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotSecret signs snapshot URLs. Without one a random key is made at
+// startup, so snapshots only last as long as the process.
+var snapshotSecret string
+
+var (
+	snapshotKeyOnce sync.Once
+	snapshotKeyData []byte
+)
+
+const (
+	defaultSnapshotTTL = 7 * 24 * time.Hour
+	maxSnapshotTTL     = 90 * 24 * time.Hour
+)
+
+func snapshotKey() []byte {
+	snapshotKeyOnce.Do(func() {
+		if snapshotSecret != "" {
+			snapshotKeyData = []byte(snapshotSecret)
+			return
+		}
+		snapshotKeyData = make([]byte, 32)
+		rand.Read(snapshotKeyData)
+	})
+	return snapshotKeyData
+}
+
+// snapshotSignature covers the hash, the time the snapshot is of and when
+// it stops working
+func snapshotSignature(hash string, at, expires int64) []byte {
+	mac := hmac.New(sha256.New, snapshotKey())
+	mac.Write([]byte(hash + "\n" + strconv.FormatInt(at, 10) + "\n" + strconv.FormatInt(expires, 10)))
+	return mac.Sum(nil)
+}
+
+// snapshotURL signs a link to the analytics of hash as of at
+func snapshotURL(r *http.Request, hash string, at, expires time.Time) string {
+	q := url.Values{}
+	q.Set("at", strconv.FormatInt(at.Unix(), 10))
+	q.Set("exp", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", hex.EncodeToString(snapshotSignature(hash, at.Unix(), expires.Unix())))
+
+	p := appPath("/snapshot/"+hash) + "?" + q.Encode()
+	if baseURL != "" {
+		return strings.TrimSuffix(baseURL, "/") + p
+	}
+	return requestOrigin(r) + p
+}
+
+// shareHandler serves POST /share/<hash>, taking an optional ttl (a
+// duration, a week by default) and answering with the snapshot URL
+func shareHandler(w http.ResponseWriter, r *http.Request, m string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		apiError(w, http.StatusMethodNotAllowed, "only POST is supported")
+		return
+	}
+
+	ttl := defaultSnapshotTTL
+	if v := r.FormValue("ttl"); v != "" {
+		var err error
+		ttl, err = time.ParseDuration(v)
+		if err != nil || ttl <= 0 || ttl > maxSnapshotTTL {
+			apiError(w, http.StatusBadRequest, "ttl must be a positive duration of at most "+maxSnapshotTTL.String())
+			return
+		}
+	}
+
+	_, err := store.LoadLink(m)
+	if err != nil {
+		apiError(w, http.StatusNotFound, "no such link")
+		return
+	}
+
+	now := time.Now()
+	expires := now.Add(ttl)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"url":     snapshotURL(r, m, now, expires),
+		"at":      now.Truncate(time.Second),
+		"expires": expires.Truncate(time.Second),
+	})
+}
+
+type snapshot struct {
+	GoTo    *Link
+	At      time.Time
+	Expires time.Time
+	Summary *Summary
+}
+
+// snapshotHandler serves GET /snapshot/<hash>, showing only the hits from
+// before the time the URL was signed for
+func snapshotHandler(w http.ResponseWriter, r *http.Request, m string) {
+	q := r.URL.Query()
+	at, err1 := strconv.ParseInt(q.Get("at"), 10, 64)
+	expires, err2 := strconv.ParseInt(q.Get("exp"), 10, 64)
+	sig, err3 := hex.DecodeString(q.Get("sig"))
+	if err1 != nil || err2 != nil || err3 != nil || !hmac.Equal(sig, snapshotSignature(m, at, expires)) {
+		http.Error(w, "invalid snapshot link", http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() >= expires {
+		http.Error(w, "this snapshot link has expired", http.StatusGone)
+		return
+	}
+
+	l, err := store.LoadLink(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	hits, err := store.LoadHits(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// hits are in the order they were recorded, but clocks can go backwards
+	cutoff := time.Unix(at+1, 0)
+	var shown []Hit
+	for _, h := range hits {
+		if h.Time.Before(cutoff) {
+			shown = append(shown, h)
+		}
+	}
+
+	s := &snapshot{l, time.Unix(at, 0), time.Unix(expires, 0), summarizeHits(l, shown)}
+	err = templates.ExecuteTemplate(w, "snapshot.html", s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// sqlStore keeps links and hits in SQLite or PostgreSQL. Link metadata is
+// the same JSON the file store puts on its "meta: " line, and each hit is
+// stored as JSON alongside its time so it can be queried by range.
+type sqlStore struct {
+	db       *sql.DB
+	postgres bool
+	where    string // for the startup log
+}
+
+// migrations build up the schema, one step per entry. The number of steps
+// applied is kept in schema_version, so new ones must only ever be added
+// at the end. Types are spelled so both SQLite and PostgreSQL take them.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS links (
+		hash TEXT PRIMARY KEY,
+		destination TEXT NOT NULL,
+		workspace TEXT NOT NULL DEFAULT '',
+		meta TEXT NOT NULL DEFAULT '{}',
+		last_active BIGINT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS hits (
+		id %s,
+		hash TEXT NOT NULL,
+		time BIGINT NOT NULL,
+		data TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS hits_by_link ON hits (hash, time);`,
+
+	// sharedState, so aliases, API keys and nonces work across instances
+	`CREATE TABLE IF NOT EXISTS state (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		expires BIGINT NOT NULL
+	);`,
+}
+
+func openSQLiteStore(path string) (*sqlStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only takes one writer at a time anyway, and sharing one
+	//	connection keeps concurrent hits from failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	s := &sqlStore{db: db, where: "SQLite at " + path}
+	err = s.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// openPostgresStore connects to a database that several instances can
+// share, with a pool of at most maxConns connections
+func openPostgresStore(dsn string, maxConns int) (*sqlStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(maxConns)
+	db.SetMaxIdleConns(maxConns)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &sqlStore{db: db, postgres: true, where: "PostgreSQL"}
+	err = s.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// q rewrites a query's ? placeholders into PostgreSQL's $1, $2...
+func (s *sqlStore) q(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// migrate applies whichever migrations the database hasn't had yet
+func (s *sqlStore) migrate() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// instances starting at the same time take turns
+	if s.postgres {
+		_, err = tx.Exec("SELECT pg_advisory_xact_lock(7261626)")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+	var version int
+	err = tx.QueryRow("SELECT version FROM schema_version").Scan(&version)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec("INSERT INTO schema_version (version) VALUES (0)")
+	}
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema is version %d, newer than this build knows (%d)", version, len(migrations))
+	}
+
+	idType := "INTEGER PRIMARY KEY"
+	if s.postgres {
+		idType = "BIGSERIAL PRIMARY KEY"
+	}
+	for i := version; i < len(migrations); i++ {
+		_, err = tx.Exec(strings.ReplaceAll(migrations[i], "%s", idType))
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	_, err = tx.Exec(s.q("UPDATE schema_version SET version = ?"), len(migrations))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sealed and unsealed apply encryption at rest when it's configured, as
+// the file store does
+func sealed(value string) (string, error) {
+	if atRest == nil {
+		return value, nil
+	}
+	return seal(value)
+}
+
+func (s *sqlStore) SaveLink(l *Link) error {
+	if l.Workspace != "" && !validWorkspace.MatchString(l.Workspace) {
+		return fmt.Errorf("invalid workspace %q", l.Workspace)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// saving a link that already exists keeps its creation time, and
+	//	saving over a different link is refused. The row stays locked
+	//	until we're done, so another instance can't change it in between;
+	//	SQLite's single connection already keeps writes apart.
+	lock := ""
+	if s.postgres {
+		lock = " FOR UPDATE"
+	}
+	var oldDestination, oldMeta string
+	err = tx.QueryRow(s.q("SELECT destination, meta FROM links WHERE hash = ?"+lock), l.Hash).Scan(&oldDestination, &oldMeta)
+	exists := err == nil
+	if err == nil {
+		oldDestination, err = unseal(oldDestination)
+		if err != nil {
+			return err
+		}
+		if oldDestination != l.Destination {
+			return errCodeTaken
+		}
+		var oldLink Link
+		if json.Unmarshal([]byte(oldMeta), &oldLink) == nil && !oldLink.Created.IsZero() {
+			l.Created = oldLink.Created
+		}
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	destination, err := sealed(l.Destination)
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if exists {
+		_, err = tx.Exec(s.q("UPDATE links SET destination = ?, workspace = ?, meta = ?, last_active = ? WHERE hash = ?"),
+			destination, l.Workspace, string(meta), time.Now().UnixNano(), l.Hash)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	// there was no row to lock, so another instance could be creating
+	//	the same hash right now; whichever inserts second is refused
+	//	rather than overwriting the first
+	result, err := tx.Exec(s.q(`INSERT INTO links (hash, destination, workspace, meta, last_active) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO NOTHING`),
+		l.Hash, destination, l.Workspace, string(meta), time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errCodeTaken
+	}
+	return tx.Commit()
+}
+
+// CreateLink leaves it to the database to refuse a hash that's taken, so
+// instances sharing it can't both create the same code
+func (s *sqlStore) CreateLink(l *Link) error {
+	if l.Workspace != "" && !validWorkspace.MatchString(l.Workspace) {
+		return fmt.Errorf("invalid workspace %q", l.Workspace)
+	}
+	destination, err := sealed(l.Destination)
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.Exec(s.q(`INSERT INTO links (hash, destination, workspace, meta, last_active) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO NOTHING`),
+		l.Hash, destination, l.Workspace, string(meta), time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errCodeTaken
+	}
+	return nil
+}
+
+func (s *sqlStore) LoadLink(hash string) (*Link, error) {
+	var destination, workspace, meta string
+	var lastActive int64
+	err := s.db.QueryRow(s.q("SELECT destination, workspace, meta, last_active FROM links WHERE hash = ?"), hash).
+		Scan(&destination, &workspace, &meta, &lastActive)
+	if err == sql.ErrNoRows {
+		return nil, &fs.PathError{Op: "open", Path: hash, Err: fs.ErrNotExist}
+	} else if err != nil {
+		return nil, err
+	}
+
+	destination, err = unseal(destination)
+	if err != nil {
+		return nil, err
+	}
+	l := &Link{Destination: destination, Hash: hash, Workspace: workspace, LastActive: time.Unix(0, lastActive)}
+	err = json.Unmarshal([]byte(meta), l)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (s *sqlStore) AppendHit(hash string, h Hit) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	line := string(data)
+	if encryptHits {
+		line, err = sealed(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(s.q("UPDATE links SET last_active = ? WHERE hash = ?"), time.Now().UnixNano(), hash)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &fs.PathError{Op: "open", Path: hash, Err: fs.ErrNotExist}
+	}
+	_, err = tx.Exec(s.q("INSERT INTO hits (hash, time, data) VALUES (?, ?, ?)"), hash, h.Time.UnixNano(), line)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) LoadHits(hash string) ([]Hit, error) {
+	_, err := s.LoadLink(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(s.q("SELECT data FROM hits WHERE hash = ? ORDER BY id"), hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []Hit
+	for rows.Next() {
+		var data string
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		data, err = unseal(data)
+		if err != nil {
+			return nil, err
+		}
+		var h Hit
+		err = json.Unmarshal([]byte(data), &h)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+func (s *sqlStore) ListLinks() ([]string, error) {
+	rows, err := s.db.Query("SELECT hash FROM links")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+func (s *sqlStore) DeleteLink(hash string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(s.q("DELETE FROM links WHERE hash = ?"), hash)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &fs.PathError{Op: "remove", Path: hash, Err: fs.ErrNotExist}
+	}
+	_, err = tx.Exec(s.q("DELETE FROM hits WHERE hash = ?"), hash)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) RenameLink(hash, code string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var taken int
+	err = tx.QueryRow(s.q("SELECT COUNT(*) FROM links WHERE hash = ?"), code).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return fs.ErrExist
+	}
+
+	result, err := tx.Exec(s.q("UPDATE links SET hash = ? WHERE hash = ?"), code, hash)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &fs.PathError{Op: "rename", Path: hash, Err: fs.ErrNotExist}
+	}
+	_, err = tx.Exec(s.q("UPDATE hits SET hash = ? WHERE hash = ?"), code, hash)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) SetDestination(hash, destination string) error {
+	destination, err := sealed(destination)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.Exec(s.q("UPDATE links SET destination = ? WHERE hash = ?"), destination, hash)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &fs.PathError{Op: "open", Path: hash, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+// Writable makes a change that's rolled back, which fails on a read-only
+// database or a lost connection
+func (s *sqlStore) Writable() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("UPDATE schema_version SET version = version")
+	return err
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// expiresAt stores an expiry as nanoseconds, 0 for never
+func expiresAt(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func (s *sqlStore) GetState(name string) ([]byte, error) {
+	var value string
+	err := s.db.QueryRow(s.q("SELECT value FROM state WHERE name = ? AND (expires = 0 OR expires > ?)"), name, time.Now().UnixNano()).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	} else if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+func (s *sqlStore) PutState(name string, value []byte, expires time.Time) error {
+	_, err := s.db.Exec(s.q(`INSERT INTO state (name, value, expires) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value, expires = excluded.expires`),
+		name, string(value), expiresAt(expires))
+	return err
+}
+
+func (s *sqlStore) DeleteState(name string) error {
+	result, err := s.db.Exec(s.q("DELETE FROM state WHERE name = ?"), name)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (s *sqlStore) ListState(prefix string) (map[string][]byte, error) {
+	// names are ours and never have LIKE's wildcards in them
+	rows, err := s.db.Query(s.q("SELECT name, value FROM state WHERE name LIKE ? AND (expires = 0 OR expires > ?)"), prefix+"%", time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := map[string][]byte{}
+	for rows.Next() {
+		var name, value string
+		err = rows.Scan(&name, &value)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = []byte(value)
+	}
+	return values, rows.Err()
+}
+
+// stateClaims counts ClaimState calls, so every so often one of them can
+// clear out expired state that nothing has claimed again
+var stateClaims atomic.Int64
+
+// ClaimState inserts name, or takes over an expired one, in one statement,
+// so two instances can't both claim it
+func (s *sqlStore) ClaimState(name string, expires time.Time) (bool, error) {
+	now := time.Now().UnixNano()
+	if stateClaims.Add(1)%1000 == 0 {
+		_, err := s.db.Exec(s.q("DELETE FROM state WHERE expires <> 0 AND expires <= ?"), now)
+		if err != nil {
+			return false, err
+		}
+	}
+	result, err := s.db.Exec(s.q(`INSERT INTO state (name, value, expires) VALUES (?, '', ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value, expires = excluded.expires
+		WHERE state.expires <> 0 AND state.expires <= ?`),
+		name, expiresAt(expires), now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// secret says whether a secret is configured without showing it
+func secret(value string) string {
+	if value == "" {
+		return "unset"
+	}
+	return "set"
+}
+
+// logStartup reports how the server ended up configured, so a deployment's
+// logs show what it's actually running with. Secrets are never printed.
+func logStartup(listen string) {
+	dir, err := os.Getwd()
+	if err != nil {
+		dir = "."
+	}
+
+	auth := "open"
+	switch {
+	case apiToken != "" && jwtEnabled():
+		auth = "token or JWT"
+	case apiToken != "":
+		auth = "token"
+	case jwtEnabled():
+		auth = "JWT"
+	}
+	if apiKeysIssued() {
+		if auth == "open" {
+			auth = "API keys"
+		} else {
+			auth += " or API keys"
+		}
+	}
+	if loginEnabled() {
+		if auth == "open" {
+			auth = "login"
+		} else {
+			auth += " or login"
+		}
+	}
+
+	base := baseURL
+	if base == "" {
+		base = "(request host)"
+	}
+	if customDomains {
+		base += ", custom domains"
+	}
+
+	log.Printf("linkanalytics listening on %s (%s)", listen, tlsMode())
+	if httpRedirect != "" {
+		log.Printf("  redirecting plain HTTP on %s to HTTPS", httpRedirect)
+	}
+	encryption := "off"
+	if atRest != nil {
+		encryption = "destinations"
+		if encryptHits {
+			encryption += " and hits"
+		}
+	}
+	where := "files in " + dir
+	if s, ok := unwrapStore(store).(*sqlStore); ok {
+		where = s.where
+	}
+	log.Printf("  storage: %s, encrypted: %s", where, encryption)
+	log.Printf("  base URL: %s, base path: %q", base, basePath)
+	log.Printf("  API auth: %s (api-token %s, jwt-secret %s, jwks-url %q, scope %q)", auth, secret(apiToken), secret(jwtSecret), jwksURL, jwtScope)
+	login := "off"
+	switch {
+	case adminPassword != "" && len(htpasswdUsers) > 0:
+		login = fmt.Sprintf("admin password or %d htpasswd users", len(htpasswdUsers))
+	case adminPassword != "":
+		login = "admin password"
+	case len(htpasswdUsers) > 0:
+		login = fmt.Sprintf("%d htpasswd users", len(htpasswdUsers))
+	}
+	if loginEnabled() && len(admins) > 0 {
+		login += fmt.Sprintf(", %d admin users", len(admins))
+	}
+	log.Printf("  login: %s, session secret: %s", login, secret(sessionSecret))
+	log.Printf("  snapshot secret: %s", secret(snapshotSecret))
+	var rules []string
+	if len(allowedHosts) > 0 {
+		rules = append(rules, "only to "+strings.Join(allowedHosts, ", "))
+	}
+	if len(deniedHosts) > 0 {
+		rules = append(rules, "never to "+strings.Join(deniedHosts, ", "))
+	}
+	if denyShortLinks {
+		rules = append(rules, "no short links")
+	}
+	if len(rules) > 0 {
+		log.Printf("  destinations: %s", strings.Join(rules, "; "))
+	}
+	if len(corsOrigins) > 0 {
+		var origins []string
+		for origin := range corsOrigins {
+			origins = append(origins, origin)
+		}
+		sort.Strings(origins)
+		log.Printf("  CORS origins: %s", strings.Join(origins, ", "))
+	}
+	anonymized := anonymizeIP
+	if anonymized == "hash" {
+		anonymized += ", salt " + secret(ipSalt)
+	}
+	if anonymized == "" {
+		anonymized = "off"
+	}
+	geoip := "off"
+	if geoDB != nil {
+		geoip = geoDB.Metadata.DatabaseType
+	}
+	log.Printf("  GeoIP: %s", geoip)
+	log.Printf("  schedule time zone: %s", scheduleTimezone)
+	log.Printf("  trusted proxies: %d, IP anonymization: %s", len(trustedProxies), anonymized)
+	log.Printf("  maintenance: %v", inMaintenance())
+	var patterns []string
+	for _, rt := range routes {
+		patterns = append(patterns, rt.Pattern)
+	}
+	log.Printf("  routes: %s", strings.Join(patterns, " "))
+}
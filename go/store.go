@@ -0,0 +1,70 @@
+package main
+
+import "errors"
+
+// A Store persists links and their hits. Lookups of links that don't exist
+// return an error for which os.IsNotExist is true, so handlers can tell a
+// missing link from a broken store.
+type Store interface {
+	// SaveLink creates or updates a link, keeping the hits and creation
+	// time of one that already exists. It returns errCodeTaken rather
+	// than overwrite a link with the same hash and a different
+	// destination.
+	SaveLink(l *Link) error
+
+	// CreateLink saves a new link, returning errCodeTaken if any link
+	// already has its hash, whatever its destination. It never changes
+	// an existing link, even when two instances create the same code at
+	// once.
+	CreateLink(l *Link) error
+
+	LoadLink(hash string) (*Link, error)
+
+	AppendHit(hash string, h Hit) error
+
+	// LoadHits returns every hit recorded for a link, oldest first
+	LoadHits(hash string) ([]Hit, error)
+
+	// ListLinks returns the hash of every link, in no particular order
+	ListLinks() ([]string, error)
+
+	DeleteLink(hash string) error
+
+	// RenameLink moves a link and its hits to a new hash, failing with an
+	// error for which os.IsExist is true if that hash is taken
+	RenameLink(hash, code string) error
+
+	// SetDestination points an existing link somewhere else, keeping its
+	// hash, settings and hits
+	SetDestination(hash, destination string) error
+
+	// Writable returns an error if the store can't be written to right
+	// now, for readiness checks
+	Writable() error
+
+	// Close is called once at exit, after the last request
+	Close() error
+}
+
+// store is where everything is kept
+var store Store = fileStore{}
+
+// unwrapStore returns the Store that wrappers like meteredStore and
+// cachedStore were put around
+func unwrapStore(s Store) Store {
+	for {
+		switch w := s.(type) {
+		case meteredStore:
+			s = w.Store
+		case *cachedStore:
+			s = w.Store
+		default:
+			return s
+		}
+	}
+}
+
+// errCodeTaken is returned by SaveLink instead of overwriting a link that
+// has the same code but a different destination, and by CreateLink for any
+// code that's in use
+var errCodeTaken = errors.New("that code is already taken by another link")
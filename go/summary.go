@@ -0,0 +1,271 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"expvar"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Summary holds the aggregates shown on a link's analytics page
+type Summary struct {
+	Total   int
+	Uniques int        // the sum of each day's unique visitors
+	Daily   []DayCount // oldest day first
+	LastHit time.Time
+
+	// Classified is set once any click was marked new or returning
+	Classified bool
+
+	Breakdowns []Breakdown
+
+	// TimeToClick buckets clicks by how long after the link was created
+	//	they came; it's nil for links without a creation time
+	TimeToClick []Count
+
+	// conversions aren't clicks, so they're counted here and nowhere else
+	Attributed, Unattributed int
+
+	// Opens counts loads of the tracking pixel, which aren't clicks
+	//	either
+	Opens int
+
+	// OverLimit counts clicks turned away by the link's MaxClicks
+	OverLimit int
+
+	// Bots counts clicks from crawlers and link previews, which are left
+	//	out of everything else unless -count-bots is set
+	Bots int
+}
+
+type DayCount struct {
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+
+	// only clicks classified with -classify-visitors are counted here
+	New       int `json:"new,omitempty"`
+	Returning int `json:"returning,omitempty"`
+
+	// Uniques counts distinct VisitorHashes, which only mean anything
+	//	within a day
+	Uniques int `json:"uniques,omitempty"`
+}
+
+// clickDelays are the TimeToClick buckets, each one counting the clicks
+// that came after the previous bucket's limit but within its own
+var clickDelays = []struct {
+	label string
+	limit time.Duration
+}{
+	{"first hour", time.Hour},
+	{"first day", 24 * time.Hour},
+	{"first week", 7 * 24 * time.Hour},
+	{"first 30 days", 30 * 24 * time.Hour},
+	{"later", math.MaxInt64},
+}
+
+func timeToClick(created time.Time, hits []Hit) []Count {
+	counts := make([]Count, len(clickDelays))
+	for i, d := range clickDelays {
+		counts[i].Key = d.label
+	}
+	for _, h := range hits {
+		// clicks from before the link "existed" come from clock changes
+		//	and count as immediate
+		delay := h.Time.Sub(created)
+		for i, d := range clickDelays {
+			if delay < d.limit {
+				counts[i].Count++
+				break
+			}
+		}
+	}
+	return counts
+}
+
+func summarize(hash string) (*Summary, error) {
+	l, err := store.LoadLink(hash)
+	if err != nil {
+		return nil, err
+	}
+	hits, err := store.LoadHits(hash)
+	if err != nil {
+		return nil, err
+	}
+	return summarizeHits(l, hits), nil
+}
+
+// summarizeHits aggregates hits on l, which don't have to be all of them
+func summarizeHits(l *Link, hits []Hit) *Summary {
+	s := &Summary{}
+	clicks := hits[:0:0]
+	for _, h := range hits {
+		switch {
+		case h.Conversion && h.Attributed:
+			s.Attributed++
+		case h.Conversion:
+			s.Unattributed++
+		case h.Pixel:
+			s.Opens++
+		case h.OverLimit:
+			s.OverLimit++
+		case h.fromBot():
+			s.Bots++
+		default:
+			clicks = append(clicks, h)
+		}
+	}
+	hits = clicks
+
+	days := map[string]*DayCount{}
+	seen := map[string]bool{}
+	for _, h := range hits {
+		s.Total++
+		day := h.Time.Format("2006-01-02")
+		if days[day] == nil {
+			days[day] = &DayCount{Day: day}
+		}
+		days[day].Count++
+		if h.VisitorHash != "" && !seen[day+h.VisitorHash] {
+			seen[day+h.VisitorHash] = true
+			days[day].Uniques++
+			s.Uniques++
+		}
+		switch h.Visit {
+		case "new":
+			days[day].New++
+			s.Classified = true
+		case "returning":
+			days[day].Returning++
+			s.Classified = true
+		}
+		if h.Time.After(s.LastHit) {
+			s.LastHit = h.Time
+		}
+	}
+
+	for _, d := range days {
+		s.Daily = append(s.Daily, *d)
+	}
+	sort.Slice(s.Daily, func(i, j int) bool { return s.Daily[i].Day < s.Daily[j].Day })
+
+	s.Breakdowns = breakdowns(hits)
+	if !l.Created.IsZero() {
+		s.TimeToClick = timeToClick(l.Created, hits)
+	}
+	return s
+}
+
+// summaries caches computed Summaries by hash, keeping the
+// summaryCacheSize most recently used. gotHit drops a link's entry so the
+// next view recomputes it.
+var summaries = struct {
+	sync.Mutex
+	m   map[string]*list.Element
+	lru *list.List // of *cachedEntry, most recently used first
+
+	// version goes up on every invalidation, so a summary computed while
+	//	a hit came in isn't cached over the newer data
+	version uint64
+}{m: map[string]*list.Element{}, lru: list.New()}
+
+type cachedEntry struct {
+	hash    string
+	summary *Summary
+}
+
+var summaryCacheSize = 1000
+
+var (
+	summaryCacheHits   = expvar.NewInt("summary_cache_hits")
+	summaryCacheMisses = expvar.NewInt("summary_cache_misses")
+)
+
+func cachedSummary(hash string) (*Summary, error) {
+	summaries.Lock()
+	if e, found := summaries.m[hash]; found {
+		summaries.lru.MoveToFront(e)
+		s := e.Value.(*cachedEntry).summary
+		summaries.Unlock()
+		summaryCacheHits.Add(1)
+		return s, nil
+	}
+	version := summaries.version
+	summaries.Unlock()
+	summaryCacheMisses.Add(1)
+
+	s, err := summarize(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries.Lock()
+	defer summaries.Unlock()
+	if summaries.version != version || summaryCacheSize <= 0 {
+		return s, nil
+	}
+	if e, found := summaries.m[hash]; found {
+		// someone else computed it at the same time
+		summaries.lru.MoveToFront(e)
+		return e.Value.(*cachedEntry).summary, nil
+	}
+	summaries.m[hash] = summaries.lru.PushFront(&cachedEntry{hash, s})
+	for summaries.lru.Len() > summaryCacheSize {
+		oldest := summaries.lru.Back()
+		summaries.lru.Remove(oldest)
+		delete(summaries.m, oldest.Value.(*cachedEntry).hash)
+	}
+	return s, nil
+}
+
+func invalidateSummary(hash string) {
+	summaries.Lock()
+	summaries.version++
+	if e, found := summaries.m[hash]; found {
+		summaries.lru.Remove(e)
+		delete(summaries.m, hash)
+	}
+	summaries.Unlock()
+}
+
+var (
+	warmupTotal = expvar.NewInt("warmup_total")
+	warmupDone  = expvar.NewInt("warmup_done")
+)
+
+// warmSummaries precomputes summaries for the n most recently active links
+func warmSummaries(ctx context.Context, n int) {
+	hashes, err := store.ListLinks()
+	if err != nil {
+		return
+	}
+
+	type candidate struct {
+		hash       string
+		lastActive time.Time
+	}
+	var candidates []candidate
+	for _, hash := range hashes {
+		l, err := store.LoadLink(hash)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, candidate{hash, l.LastActive})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].lastActive.After(candidates[j].lastActive) })
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+
+	warmupTotal.Set(int64(len(candidates)))
+	for _, c := range candidates {
+		if ctx.Err() != nil {
+			return
+		}
+		cachedSummary(c.hash)
+		warmupDone.Add(1)
+	}
+}
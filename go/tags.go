@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Tags group links, like every link in a campaign, so they can be listed
+// and counted together and changed in bulk
+
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if !validTag.MatchString(tag) {
+			return errors.New("invalid tag " + tag + "; tags are lowercase letters, digits, - and _")
+		}
+	}
+	return nil
+}
+
+// parseTags reads tags typed into a form, separated by commas or spaces.
+// They're lowercased and each kept once.
+func parseTags(s string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, tag := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// TagsText is l's tags the way the forms take them
+func (l *Link) TagsText() string {
+	return strings.Join(l.Tags, ", ")
+}
+
+// A tagCount is how many links have a tag and how many clicks they've had
+// between them
+type tagCount struct {
+	Tag     string `json:"tag"`
+	Links   int    `json:"links"`
+	Clicks  int    `json:"clicks"`
+	Uniques int    `json:"uniques"`
+}
+
+// tagCounts adds up the links the requester can see by tag, most clicked
+// tag first
+func tagCounts(r *http.Request) ([]tagCount, error) {
+	hashes, err := store.ListLinks()
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]*tagCount{}
+	for _, hash := range hashes {
+		l, err := store.LoadLink(hash)
+		if err != nil || len(l.Tags) == 0 || !canSee(r, l) {
+			continue
+		}
+		s, err := cachedSummary(hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range l.Tags {
+			c := counts[tag]
+			if c == nil {
+				c = &tagCount{Tag: tag}
+				counts[tag] = c
+			}
+			c.Links++
+			c.Clicks += s.Total
+			c.Uniques += s.Uniques
+		}
+	}
+
+	list := []tagCount{}
+	for _, c := range counts {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Clicks != list[j].Clicks {
+			return list[i].Clicks > list[j].Clicks
+		}
+		return list[i].Tag < list[j].Tag
+	})
+	return list, nil
+}
+
+// apiTagsHandler serves GET /api/v1/tags, every tag with its link and click
+// counts
+func apiTagsHandler(w http.ResponseWriter, r *http.Request) {
+	counts, err := tagCounts(r)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tags": counts})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// the templates are built in, so the binary runs from anywhere
+//
+//go:embed *.html
+var embeddedTemplates embed.FS
+
+// templatesDir holds customized copies of any of the templates. Ones it
+// doesn't have are still taken from the built-in set.
+var templatesDir string
+
+func loadTemplates(dir string) (*template.Template, error) {
+	t := template.New("").Funcs(templateFuncs)
+	for _, name := range templateFiles {
+		contents, err := fs.ReadFile(embeddedTemplates, name)
+		if err != nil {
+			return nil, err
+		}
+		if dir != "" {
+			custom, err := os.ReadFile(filepath.Join(dir, name))
+			if err == nil {
+				contents = custom
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		_, err = t.New(name).Parse(string(contents))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+// A Bucket is the clicks in one hour, day or week of a timeseries
+type Bucket struct {
+	Start   time.Time `json:"start"`
+	Clicks  int       `json:"clicks"`
+	Uniques int       `json:"uniques"`
+}
+
+// A Timeseries is a link's clicks bucketed by hour, day or week, with
+// every bucket from the first click to the last, empty or not
+type Timeseries struct {
+	Granularity string   `json:"granularity"`
+	Buckets     []Bucket `json:"buckets"`
+
+	// Truncated is set when only the most recent maxBuckets are kept
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+const maxBuckets = 2000
+
+var errGranularity = errors.New("granularity must be hour, day or week")
+
+// bucketStart is the start of the bucket t falls in, in local time. Weeks
+// start on Monday.
+func bucketStart(t time.Time, granularity string) time.Time {
+	y, m, d := t.Date()
+	switch granularity {
+	case "hour":
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+	case "week":
+		back := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-back, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func nextBucket(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case "hour":
+		return start.Add(time.Hour)
+	case "week":
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// isClick reports whether a hit counts as a click, the same way Summary
+// does
+func (h Hit) isClick() bool {
+	return !h.Conversion && !h.OverLimit && !h.fromBot()
+}
+
+func timeseries(hits []Hit, granularity string) (*Timeseries, error) {
+	if granularity == "" {
+		granularity = "day"
+	}
+	if granularity != "hour" && granularity != "day" && granularity != "week" {
+		return nil, errGranularity
+	}
+
+	ts := &Timeseries{Granularity: granularity, Buckets: []Bucket{}}
+	counts := map[time.Time]*Bucket{}
+	seen := map[string]bool{}
+	var first, last time.Time
+	for _, h := range hits {
+		if !h.isClick() {
+			continue
+		}
+		t := h.Time.Local()
+		start := bucketStart(t, granularity)
+		b := counts[start]
+		if b == nil {
+			b = &Bucket{Start: start}
+			counts[start] = b
+		}
+		b.Clicks++
+		// visitor hashes change daily, so uniques are per day even in
+		//	weekly buckets
+		key := start.String() + t.Format("2006-01-02") + h.VisitorHash
+		if h.VisitorHash != "" && !seen[key] {
+			seen[key] = true
+			b.Uniques++
+		}
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if start.After(last) {
+			last = start
+		}
+	}
+	if first.IsZero() {
+		return ts, nil
+	}
+
+	for start := first; !start.After(last); start = nextBucket(start, granularity) {
+		if b := counts[start]; b != nil {
+			ts.Buckets = append(ts.Buckets, *b)
+		} else {
+			ts.Buckets = append(ts.Buckets, Bucket{Start: start})
+		}
+	}
+	if len(ts.Buckets) > maxBuckets {
+		ts.Buckets = ts.Buckets[len(ts.Buckets)-maxBuckets:]
+		ts.Truncated = true
+	}
+	return ts, nil
+}
+
+// timeseriesHandler serves GET /api/v1/links/<hash>/timeseries. It takes
+// granularity (hour, day or week, default day) and the hitFilter
+// parameters.
+func timeseriesHandler(w http.ResponseWriter, r *http.Request, hash string) {
+	filter, err := parseHitFilter(r.URL.Query())
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hits, err := store.LoadHits(hash)
+	if os.IsNotExist(err) {
+		apiError(w, http.StatusNotFound, "no such link")
+		return
+	} else if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ts, err := timeseries(filter.apply(hits), r.URL.Query().Get("granularity"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ts)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	// tlsCert and tlsKey are PEM files to serve HTTPS with
+	tlsCert, tlsKey string
+
+	// autocertDomains are the hosts to get Let's Encrypt certificates for,
+	// comma separated. Certificates are never requested for other hosts,
+	// so people can't make us ask for one by pointing a name at us.
+	autocertDomains string
+	autocertCache   = "autocert"
+	autocertEmail   string
+
+	// httpRedirect is an address to also listen on with plain HTTP, which
+	// redirects everything to HTTPS (and answers Let's Encrypt's
+	// challenges, with autocert)
+	httpRedirect string
+)
+
+// setupTLS gives server a TLS config if HTTPS is turned on, and returns the
+// handler for the -http-redirect listener
+func setupTLS(server *http.Server) (http.Handler, error) {
+	certFiles := tlsCert != "" || tlsKey != ""
+	if certFiles && (tlsCert == "" || tlsKey == "") {
+		return nil, errors.New("-tls-cert and -tls-key go together")
+	}
+	if certFiles && autocertDomains != "" {
+		return nil, errors.New("use -tls-cert and -tls-key or -autocert-domains, not both")
+	}
+
+	_, port, _ := net.SplitHostPort(server.Addr)
+	var redirect http.Handler = redirectToHTTPS(port)
+	switch {
+	case certFiles:
+		// ServeTLS loads the files itself
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	case autocertDomains != "":
+		var hosts []string
+		for _, host := range strings.Split(autocertDomains, ",") {
+			host = strings.ToLower(strings.TrimSpace(host))
+			if host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(hosts...),
+			Cache:      autocert.DirCache(autocertCache),
+			Email:      autocertEmail,
+		}
+		server.TLSConfig = m.TLSConfig()
+		server.TLSConfig.MinVersion = tls.VersionTLS12
+		redirect = m.HTTPHandler(redirect)
+	default:
+		if httpRedirect != "" {
+			return nil, errors.New("-http-redirect needs HTTPS turned on")
+		}
+		return nil, nil
+	}
+	return redirect, nil
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL over HTTPS on
+// port
+func redirectToHTTPS(port string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "use HTTPS", http.StatusBadRequest)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}
+}
+
+// tlsMode is how we're serving, for the startup log
+func tlsMode() string {
+	switch {
+	case tlsCert != "":
+		return "HTTPS with " + tlsCert
+	case autocertDomains != "":
+		return "HTTPS with Let's Encrypt for " + autocertDomains
+	}
+	return "plain HTTP; terminate TLS in front"
+}
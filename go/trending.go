@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// trendingHalfLife is how long it takes a click to count for half as
+	// much in the trending ranking
+	trendingHalfLife = 24 * time.Hour
+
+	// trendingTTL is how long a computed ranking is reused
+	trendingTTL = time.Minute
+)
+
+const maxTrending = 50
+
+type trendingLink struct {
+	Hash        string  `json:"hash"`
+	Destination string  `json:"destination"`
+	ShortURL    string  `json:"short_url"`
+	Score       float64 `json:"score"`
+	Total       int     `json:"total"`
+	Owner       string  `json:"-"`
+}
+
+var trending = struct {
+	sync.Mutex
+	ranked   []trendingLink
+	computed time.Time
+}{}
+
+// trendingScore adds up a link's daily click counts, each decayed by how
+// long ago the middle of its day was
+func trendingScore(daily []DayCount, now time.Time) float64 {
+	score := 0.0
+	for _, d := range daily {
+		day, err := time.ParseInLocation("2006-01-02", d.Day, time.Local)
+		if err != nil {
+			continue
+		}
+		age := now.Sub(day.Add(12 * time.Hour))
+		if age < 0 {
+			age = 0
+		}
+		score += float64(d.Count) * math.Pow(0.5, float64(age)/float64(trendingHalfLife))
+	}
+	return score
+}
+
+// rankTrending scores every link from its cached summary. Short URLs are
+// filled in, other people's links left out and the ranking cut to
+// maxTrending by trendingFor, since those depend on the request.
+func rankTrending() ([]trendingLink, error) {
+	trending.Lock()
+	defer trending.Unlock()
+	if trending.ranked != nil && time.Since(trending.computed) < trendingTTL {
+		return trending.ranked, nil
+	}
+
+	hashes, err := store.ListLinks()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ranked := []trendingLink{}
+	for _, hash := range hashes {
+		l, err := store.LoadLink(hash)
+		if err != nil || l.Disabled || l.Archived || l.Expired() {
+			continue
+		}
+		s, err := cachedSummary(hash)
+		if err != nil {
+			continue
+		}
+		score := trendingScore(s.Daily, now)
+		if score > 0 {
+			ranked = append(ranked, trendingLink{Hash: hash, Destination: l.Destination, Score: score, Total: s.Total, Owner: l.Owner})
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+
+	trending.ranked = ranked
+	trending.computed = now
+	return ranked, nil
+}
+
+func trendingFor(r *http.Request) ([]trendingLink, error) {
+	ranked, err := rankTrending()
+	if err != nil {
+		return nil, err
+	}
+	user, admin := currentUser(r)
+	withURLs := []trendingLink{}
+	for _, t := range ranked {
+		if len(withURLs) == maxTrending {
+			break
+		}
+		if !admin && t.Owner != user {
+			continue
+		}
+		t.ShortURL = shareableURL(r, t.Hash)
+		withURLs = append(withURLs, t)
+	}
+	return withURLs, nil
+}
+
+// trendingHandler serves /trending
+func trendingHandler(w http.ResponseWriter, r *http.Request) {
+	ranked, err := trendingFor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = templates.ExecuteTemplate(w, "trending.html", ranked)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// apiTrendingHandler serves GET /api/trending
+func apiTrendingHandler(w http.ResponseWriter, r *http.Request) {
+	ranked, err := trendingFor(r)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"half_life": trendingHalfLife.String(), "links": ranked})
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// visitorHash identifies a visitor for one day without storing who they
+// are: it's keyed with a salt that changes every day, so the same address
+// and browser hash differently tomorrow and visits can't be linked across
+// days. Each day's salt comes from -ip-salt (random per run without one).
+func visitorHash(ip, userAgent string, t time.Time) string {
+	day := hmac.New(sha256.New, ipSaltKey())
+	day.Write([]byte(t.Format("2006-01-02")))
+
+	mac := hmac.New(sha256.New, day.Sum(nil))
+	mac.Write([]byte(ip + "\n" + userAgent))
+	return hex.EncodeToString(mac.Sum(nil)[:12])
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// baseURL is the scheme and host short links are shared under, e.g.
+	// "https://sho.rt". When it's empty we use whatever host the request
+	// came in on.
+	baseURL string
+
+	// basePath is a prefix every route is served under, for running behind
+	// a proxy that forwards e.g. "/links/..." to us. It's either empty or
+	// starts with a slash and has no trailing slash.
+	basePath string
+
+	// customDomains makes short links use the request's host even when
+	// baseURL is set, so each domain pointed at us gets its own links
+	customDomains bool
+)
+
+func cleanBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// appPath prefixes a route with the base path; templates use it as "path"
+func appPath(route string) string {
+	return basePath + route
+}
+
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// shareableURL builds the public /go/ URL for a link. r may be nil when
+// there's no request to take a host from, in which case the URL is only
+// absolute if baseURL is set.
+func shareableURL(r *http.Request, hash string) string {
+	return absoluteURL(r, appPath("/go/"+hash))
+}
+
+// absoluteURL turns a path from appPath into a whole URL in the same way
+func absoluteURL(r *http.Request, p string) string {
+	switch {
+	case customDomains && r != nil && r.Host != "":
+		return requestOrigin(r) + p
+	case baseURL != "":
+		return strings.TrimSuffix(baseURL, "/") + p
+	case r != nil && r.Host != "":
+		return requestOrigin(r) + p
+	}
+	return p
+}
+
+// defaultPort is the -port default, from the environment if it's set there.
+// PORT is what most container platforms tell us to listen on.
+func defaultPort() string {
+	if p := os.Getenv("LINKANALYTICS_PORT"); p != "" {
+		return p
+	}
+	if p := os.Getenv("PORT"); p != "" {
+		return p
+	}
+	return "8080"
+}
+
+// listenAddress joins -listen and -port into an address for the server
+func listenAddress(host, port string) (string, error) {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port), nil
+}
+
+// checkBaseURL makes sure -base-url is just a scheme and host (and maybe a
+// port), since paths are added to it as is
+func checkBaseURL(base string) error {
+	if base == "" {
+		return nil
+	}
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("-base-url %q must look like https://sho.rt", base)
+	}
+	if strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("-base-url %q must not have a path; use -base-path for that", base)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestShareableURL(t *testing.T) {
+	plain := httptest.NewRequest(http.MethodGet, "/", nil)
+	plain.Host = "links.example.com"
+	forwarded := httptest.NewRequest(http.MethodGet, "/", nil)
+	forwarded.Host = "links.example.com"
+	forwarded.Header.Set("X-Forwarded-Proto", "https")
+	direct := httptest.NewRequest(http.MethodGet, "/", nil)
+	direct.Host = "links.example.com"
+	direct.TLS = &tls.ConnectionState{}
+	other := httptest.NewRequest(http.MethodGet, "/", nil)
+	other.Host = "go.example.org"
+
+	tests := []struct {
+		name          string
+		baseURL       string
+		basePath      string
+		customDomains bool
+		r             *http.Request
+		want          string
+	}{
+		{name: "request host", r: plain, want: "http://links.example.com/go/abc1234"},
+		{name: "behind a TLS proxy", r: forwarded, want: "https://links.example.com/go/abc1234"},
+		{name: "TLS", r: direct, want: "https://links.example.com/go/abc1234"},
+		{name: "base URL wins", baseURL: "https://sho.rt", r: plain, want: "https://sho.rt/go/abc1234"},
+		{name: "base URL trailing slash", baseURL: "https://sho.rt/", r: plain, want: "https://sho.rt/go/abc1234"},
+		{name: "base URL without a request", baseURL: "https://sho.rt", want: "https://sho.rt/go/abc1234"},
+		{name: "base path", basePath: "/links", r: plain, want: "http://links.example.com/links/go/abc1234"},
+		{name: "base URL and path", baseURL: "https://sho.rt", basePath: "/links", want: "https://sho.rt/links/go/abc1234"},
+		{name: "custom domains", baseURL: "https://sho.rt", customDomains: true, r: other, want: "http://go.example.org/go/abc1234"},
+		{name: "custom domains without a request", baseURL: "https://sho.rt", customDomains: true, want: "https://sho.rt/go/abc1234"},
+		{name: "nothing to go on", want: "/go/abc1234"},
+		{name: "nothing but a path", basePath: "/links", want: "/links/go/abc1234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldBaseURL, oldBasePath, oldCustom := baseURL, basePath, customDomains
+			baseURL, basePath, customDomains = tt.baseURL, tt.basePath, tt.customDomains
+			t.Cleanup(func() { baseURL, basePath, customDomains = oldBaseURL, oldBasePath, oldCustom })
+
+			got := shareableURL(tt.r, "abc1234")
+			if got != tt.want {
+				t.Errorf("shareableURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
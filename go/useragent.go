@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// A userAgent is what we can tell about a visitor from their User-Agent
+// header. It's deliberately coarse: a few substring checks get the common
+// browsers right without keeping a database of every UA ever seen.
+type userAgent struct {
+	Browser string
+	OS      string
+	Device  string // "desktop", "mobile", "tablet" or "bot"
+}
+
+// browserMarkers are checked in order, since e.g. Chrome's UA also says
+// Safari and Edge's also says Chrome
+var browserMarkers = []struct{ marker, name string }{
+	{"Edg/", "Edge"},
+	{"EdgiOS", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser", "Samsung Internet"},
+	{"FxiOS", "Firefox"},
+	{"Firefox/", "Firefox"},
+	{"CriOS", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"Trident/", "Internet Explorer"},
+	{"MSIE ", "Internet Explorer"},
+	{"curl/", "curl"},
+	{"Wget/", "Wget"},
+	{"python-requests/", "Python"},
+}
+
+var osMarkers = []struct{ marker, name string }{
+	{"Windows", "Windows"},
+	{"iPhone", "iOS"},
+	{"iPad", "iOS"},
+	{"iPod", "iOS"},
+	{"Android", "Android"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"Linux", "Linux"},
+}
+
+func parseUserAgent(ua string) userAgent {
+	var parsed userAgent
+	for _, b := range browserMarkers {
+		if strings.Contains(ua, b.marker) {
+			parsed.Browser = b.name
+			break
+		}
+	}
+	for _, o := range osMarkers {
+		if strings.Contains(ua, o.marker) {
+			parsed.OS = o.name
+			break
+		}
+	}
+
+	switch {
+	case isBot(ua):
+		parsed.Device = "bot"
+	case strings.Contains(ua, "iPad"), strings.Contains(ua, "Tablet"),
+		strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile"):
+		parsed.Device = "tablet"
+	case strings.Contains(ua, "Mobile"), strings.Contains(ua, "iPhone"):
+		parsed.Device = "mobile"
+	case ua != "":
+		parsed.Device = "desktop"
+	}
+	return parsed
+}
+
+type userAgentBreakdown struct {
+	Hash     string  `json:"hash"`
+	Total    int     `json:"total"`
+	Browsers []Count `json:"browsers"`
+	OSes     []Count `json:"operating_systems"`
+	Devices  []Count `json:"devices"`
+}
+
+// apiUserAgentsHandler serves GET /api/useragents/<hash>, the browser, OS
+// and device breakdowns from the analytics page as JSON. It takes the same
+// filter parameters as /api/hits.
+func apiUserAgentsHandler(w http.ResponseWriter, r *http.Request, m string) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+
+	filter, err := parseHitFilter(r.URL.Query())
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hits, err := store.LoadHits(m)
+	if os.IsNotExist(err) {
+		apiError(w, http.StatusNotFound, "no such link")
+		return
+	} else if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	hits = filter.apply(hits)
+
+	rows := func(name string) []Count {
+		counts := enrichmentNamed(name).breakdown(hits).Rows
+		if counts == nil {
+			return []Count{}
+		}
+		return counts
+	}
+	result := userAgentBreakdown{
+		Hash:     m,
+		Total:    len(hits),
+		Browsers: rows("browsers"),
+		OSes:     rows("operating systems"),
+		Devices:  rows("devices"),
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// A link with Variants splits its clicks between them by weight, as an A/B
+// test. Each visitor keeps getting the variant they got first, through a
+// cookie, so they see one version of the page throughout.
+
+// variantCookie is the name of the cookie remembering which of hash's
+// variants a visitor was given
+func variantCookie(hash string) string {
+	return "la_variant_" + hash
+}
+
+// validateVariants checks variants and normalizes their destinations in
+// place. Weights are percentages, so they have to add up to 100.
+func validateVariants(variants []Variant) error {
+	if len(variants) == 0 {
+		return nil
+	}
+	if len(variants) < 2 {
+		return errors.New("an A/B split needs at least two variants")
+	}
+	total := 0
+	for i, v := range variants {
+		normalized, _, err := normalizeDestination(v.Destination)
+		if err != nil {
+			return errors.New("variant " + strings.TrimSpace(v.Destination) + ": " + err.Error())
+		}
+		variants[i].Destination = normalized
+		if v.Weight <= 0 {
+			return fmt.Errorf("variant %s needs a weight above 0", normalized)
+		}
+		total += v.Weight
+	}
+	if total != 100 {
+		return fmt.Errorf("variant weights are percentages, so they have to add up to 100, not %d", total)
+	}
+	return nil
+}
+
+// parseVariants reads the create form's variants, one "<weight> <url>" per
+// line
+func parseVariants(s string) ([]Variant, error) {
+	var variants []Variant
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		weight, destination, _ := strings.Cut(line, " ")
+		w, err := strconv.Atoi(strings.TrimSuffix(weight, "%"))
+		if err != nil {
+			return nil, errors.New("variants go one per line, as a percentage and a URL like: 50 https://example.com/a")
+		}
+		variants = append(variants, Variant{Destination: strings.TrimSpace(destination), Weight: w})
+	}
+	return variants, nil
+}
+
+// pickVariant returns the number of the variant to send r to, the one in
+// its cookie if it has one, and remembers it for next time
+func (l *Link) pickVariant(w http.ResponseWriter, r *http.Request) int {
+	if c, err := r.Cookie(variantCookie(l.Hash)); err == nil {
+		n, err := strconv.Atoi(c.Value)
+		if err == nil && n >= 0 && n < len(l.Variants) {
+			return n
+		}
+	}
+
+	total := 0
+	for _, v := range l.Variants {
+		total += v.Weight
+	}
+	n := 0
+	if total > 0 {
+		pick := rand.Intn(total)
+		for i, v := range l.Variants {
+			if pick < v.Weight {
+				n = i
+				break
+			}
+			pick -= v.Weight
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     variantCookie(l.Hash),
+		Value:    strconv.Itoa(n),
+		Path:     appPath("/go/" + l.Hash),
+		MaxAge:   90 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return n
+}
+
+// variantName labels a hit's variant for the analytics breakdown. It's by
+// number rather than destination, since with pass_query every click's
+// destination can be different.
+func (h Hit) variantName() string {
+	if h.Variant == 0 {
+		return ""
+	}
+	return fmt.Sprintf("variant %d", h.Variant)
+}
+
+// VariantSplit describes how l splits its clicks, numbered the same way as
+// the analytics breakdown
+func (l *Link) VariantSplit() []string {
+	var split []string
+	for i, v := range l.Variants {
+		split = append(split, fmt.Sprintf("variant %d: %d%% to %s", i+1, v.Weight, v.Destination))
+	}
+	return split
+}
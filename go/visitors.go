@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"os"
+)
+
+// classifyVisitors marks each click on /go/ as from a new or returning
+// visitor. It needs the visitor cookie, so links that don't record
+// visitors and browsers sending DNT aren't classified.
+var classifyVisitors bool
+
+type visitorSplit struct {
+	Hash      string     `json:"hash"`
+	New       int        `json:"new"`
+	Returning int        `json:"returning"`
+	Daily     []DayCount `json:"daily"`
+}
+
+// apiVisitorsHandler serves GET /api/visitors/<hash>, taking the hitFilter
+// parameters
+func apiVisitorsHandler(w http.ResponseWriter, r *http.Request, m string) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+
+	filter, err := parseHitFilter(r.URL.Query())
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	l, err := store.LoadLink(m)
+	if os.IsNotExist(err) {
+		apiError(w, http.StatusNotFound, "no such link")
+		return
+	} else if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	hits, err := store.LoadHits(m)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s := summarizeHits(l, filter.apply(hits))
+	result := visitorSplit{Hash: m, Daily: []DayCount{}}
+	for _, d := range s.Daily {
+		result.New += d.New
+		result.Returning += d.Returning
+		result.Daily = append(result.Daily, d)
+	}
+	writeJSON(w, http.StatusOK, result)
+}